@@ -0,0 +1,66 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// 原子地替换落地文件, 读取方要么读到上一个周期的完整文件, 要么读到本周期的完整文件
+// 临时文件创建在目标文件的同一目录下, 保证 rename 不跨文件系统
+
+const (
+	// DefaultFileMode 落地文件的默认权限
+	DefaultFileMode os.FileMode = 0644
+	// defaultDirMode 自动创建的上级目录的权限
+	defaultDirMode os.FileMode = 0755
+)
+
+// writeFileAtomic 将数据写入 path 同目录的临时文件, fsync 后重命名为 path
+// 上级目录不存在时自动创建, 任何错误都会删除临时文件
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, defaultDirMode); err != nil {
+		return err
+	}
+
+	tmpfile, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmpfile.Close()
+			os.Remove(tmpfile.Name())
+		}
+	}()
+
+	if _, err = tmpfile.Write(data); err != nil {
+		return err
+	}
+	if err = tmpfile.Chmod(perm); err != nil {
+		return err
+	}
+	if err = tmpfile.Sync(); err != nil {
+		return err
+	}
+	if err = tmpfile.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpfile.Name(), path); err != nil {
+		return err
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+// syncDir fsync 目录, 保证 rename 落盘, 部分平台不支持, 忽略错误
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
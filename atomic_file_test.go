@@ -0,0 +1,105 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// assertNoTempFile 目录中不应残留临时文件
+func assertNoTempFile(t *testing.T, dir string) {
+	t.Helper()
+	files, _ := ioutil.ReadDir(dir)
+	for _, f := range files {
+		if strings.Contains(f.Name(), ".tmp-") {
+			t.Fatalf("temp file %s left in %s", f.Name(), dir)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a", "b", "monitor.txt")
+
+	for _, data := range []string{"first\n", "second\n"} {
+		if err := writeFileAtomic(path, []byte(data), 0600); err != nil {
+			t.Fatalf("write error %s", err)
+		}
+		if b, _ := ioutil.ReadFile(path); string(b) != data {
+			t.Fatalf("content %q, want %q", b, data)
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("file mode %v, want 0600", info.Mode().Perm())
+	}
+	assertNoTempFile(t, filepath.Dir(path))
+}
+
+func TestWriteFileAtomicCrossDevice(t *testing.T) {
+	// 临时目录与目标不在同一文件系统时, 原先在 /tmp 创建临时文件的方式 rename 会失败
+	if info, err := os.Stat("/dev/shm"); err != nil || !info.IsDir() {
+		t.Skip("/dev/shm not available")
+	}
+	dir, err := ioutil.TempDir("/dev/shm", "monitor-atomic-")
+	if err != nil {
+		t.Skipf("/dev/shm not writable: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	t.Setenv("TMPDIR", t.TempDir())
+
+	path := filepath.Join(dir, "monitor.txt")
+	if err := writeFileAtomic(path, []byte("data\n"), DefaultFileMode); err != nil {
+		t.Fatalf("cross device write error %s", err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "data\n" {
+		t.Fatalf("unexpected content %q", b)
+	}
+	assertNoTempFile(t, dir)
+}
+
+func TestWriteFileAtomicCleanup(t *testing.T) {
+	dir := t.TempDir()
+	// 目标为非空目录, rename 失败
+	path := filepath.Join(dir, "target")
+	if err := os.MkdirAll(filepath.Join(path, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, []byte("data"), DefaultFileMode); err == nil {
+		t.Fatalf("expect rename error")
+	}
+	assertNoTempFile(t, dir)
+}
+
+func TestTextWriterDownPath(t *testing.T) {
+	conf := NewWriterConfig()
+	conf.ValidWriterName("TextWriter")
+	conf.DownPath = filepath.Join(t.TempDir(), "logs", "go-monitor.txt")
+	if err := conf.ValidateFileMode(0640); err != nil {
+		t.Fatal(err)
+	}
+	if conf.ValidateFileMode(0) == nil || conf.ValidateFileMode(os.ModeDir|0755) == nil {
+		t.Fatalf("expect invalid file mode error")
+	}
+
+	w, _ := InitWriter(conf)
+	if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+		t.Fatalf("text writer error %s", err)
+	}
+
+	info, err := os.Stat(conf.DownPath)
+	if err != nil || info.Mode().Perm() != 0640 {
+		t.Fatalf("stat %v, err %v", info, err)
+	}
+	b, _ := ioutil.ReadFile(conf.DownPath)
+	if !strings.HasPrefix(string(b), "1564617600\n\n") {
+		t.Fatalf("unexpected content %q", b)
+	}
+}
@@ -0,0 +1,90 @@
+package monitor
+
+// 去重计数指标, 每个周期一个 HyperLogLog, 输出 _Distinct 估计值及 _DistinctErr 标准误差
+// 多个周期的结果通过 Storage.Cardinality 合并, 多台主机的结果通过 MarshalBinary 传输后 Merge
+
+// WithHLLPrecision 去重计数指标 HyperLogLog 的精度, 需在 [MinHLLPrecision, MaxHLLPrecision]
+// 每个周期占用 2^precision 字节, 未配置时为 DefaultHLLPrecision
+func WithHLLPrecision(precision int) MetricOption {
+	return func(m *MetricName) error {
+		if m.Type != CardinalityMetric {
+			return &ErrMetricOption{Name: m.Name, Msg: "hll precision only for CardinalityMetric"}
+		}
+		if precision < MinHLLPrecision || precision > MaxHLLPrecision {
+			return &ErrMetricOption{Name: m.Name, Msg: "hll precision out of range"}
+		}
+		m.HLLPrecision = precision
+		return nil
+	}
+}
+
+// newCardinalitySpecValue 返回去重计数类型的特殊数据, precision 为 0 时使用默认精度
+func newCardinalitySpecValue(precision int) (*SpecValue, error) {
+	if precision == 0 {
+		precision = DefaultHLLPrecision
+	}
+	h, err := NewHyperLogLog(precision)
+	if err != nil {
+		return nil, err
+	}
+	return &SpecValue{HLL: h}, nil
+}
+
+// AddCardinality 记录去重计数指标的一个 key
+func (oms *OneMinStorage) AddCardinality(MapID int, key []byte) {
+	oms.Lock()
+	defer oms.Unlock()
+
+	if sv, ok := oms.PersistentData[MapID]; ok && sv.HLL != nil {
+		sv.Lock()
+		sv.HLL.Add(key)
+		sv.Count++
+		sv.Unlock()
+		return
+	}
+
+	oms.Data[SelfDroppedRecords]++
+	Logger.Warn("add cardinality not have map id", LogKeyID, MapID, LogKeyTs, oms.Ts.Unix())
+}
+
+// AddCardinality 记录去重计数指标的一个字符串 key, 如用户 ID 或 IP
+func (m *MONITOR) AddCardinality(MapID int, key string) {
+	m.AddCardinalityBytes(MapID, []byte(key))
+}
+
+// AddCardinalityBytes 记录去重计数指标的一个 key
+func (m *MONITOR) AddCardinalityBytes(MapID int, key []byte) {
+	defer m.recoverRecord("AddCardinality")
+	m.Core.RLock()
+	defer m.Core.RUnlock()
+	m.Core.NowMonitor.AddCardinality(MapID, key)
+}
+
+// Cardinality 合并最近 n 个已完成周期的 HyperLogLog, 得到这段时间内的去重计数
+// 指标不存在或不是去重计数类型时返回 nil
+func (s *Storage) Cardinality(MapID int, n int) *HyperLogLog {
+	if n > s.HistoryVersionNumber {
+		n = s.HistoryVersionNumber
+	}
+
+	var merged *HyperLogLog
+	for i := 1; i <= n; i++ {
+		omd := s.History(i)
+		if omd == nil {
+			continue
+		}
+
+		omd.RLock()
+		if sv, ok := omd.PersistentData[MapID]; ok && sv.HLL != nil {
+			sv.RLock()
+			if merged == nil {
+				merged = sv.HLL.Clone()
+			} else if err := merged.Merge(sv.HLL); err != nil {
+				Logger.Warn("merge cardinality error", LogKeyID, MapID, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+			}
+			sv.RUnlock()
+		}
+		omd.RUnlock()
+	}
+	return merged
+}
@@ -0,0 +1,188 @@
+package monitor
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 时钟抽象, 默认使用系统时钟, 测试时可替换为手动推进的 FakeClock
+
+// Clock 时钟接口, 提供当前时间及定时器
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer 定时器接口, 与 time.Timer 的语义相同
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// RealClock 系统时钟
+var RealClock Clock = realClock{}
+
+// realClock 系统时钟的实现
+type realClock struct{}
+
+// Now 返回当前时间
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer 返回一个系统定时器
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{t: time.NewTimer(d)}
+}
+
+// realTimer 系统定时器的包装
+type realTimer struct {
+	t *time.Timer
+}
+
+// C 返回定时器的 channel
+func (r *realTimer) C() <-chan time.Time {
+	return r.t.C
+}
+
+// Stop 停止定时器
+func (r *realTimer) Stop() bool {
+	return r.t.Stop()
+}
+
+// Reset 重置定时器
+func (r *realTimer) Reset(d time.Duration) bool {
+	return r.t.Reset(d)
+}
+
+// FakeClock 手动推进的时钟, 用于测试
+// 只有调用 Advance 时时间才会变化, 到期的定时器在 Advance 中触发
+type FakeClock struct {
+	sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock 返回一个从 now 开始的 FakeClock
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now 返回当前时间
+func (f *FakeClock) Now() time.Time {
+	f.Lock()
+	defer f.Unlock()
+	return f.now
+}
+
+// NewTimer 返回一个 d 之后到期的定时器
+func (f *FakeClock) NewTimer(d time.Duration) Timer {
+	f.Lock()
+	defer f.Unlock()
+
+	t := &fakeTimer{
+		clock:    f,
+		c:        make(chan time.Time, 1),
+		deadline: f.now.Add(d),
+		active:   true,
+	}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+// Set 将时钟直接设置为 t, 并触发到期的定时器
+func (f *FakeClock) Set(t time.Time) {
+	f.Lock()
+	f.now = t
+	f.Unlock()
+
+	f.fire()
+}
+
+// Advance 将时钟推进 d, 并触发到期的定时器
+func (f *FakeClock) Advance(d time.Duration) {
+	f.Lock()
+	f.now = f.now.Add(d)
+	f.Unlock()
+
+	f.fire()
+}
+
+// BlockUntil 阻塞直到有 n 个活动的定时器, 用于等待后台循环就绪
+func (f *FakeClock) BlockUntil(n int) {
+	for {
+		f.Lock()
+		active := 0
+		for _, t := range f.timers {
+			if t.active {
+				active++
+			}
+		}
+		f.Unlock()
+
+		if active >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fire 按到期时间顺序触发所有到期的定时器
+func (f *FakeClock) fire() {
+	f.Lock()
+	defer f.Unlock()
+
+	due := make([]*fakeTimer, 0)
+	for _, t := range f.timers {
+		if t.active && !t.deadline.After(f.now) {
+			due = append(due, t)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].deadline.Before(due[j].deadline) })
+
+	for _, t := range due {
+		t.active = false
+		select {
+		case t.c <- f.now:
+		default:
+		}
+	}
+}
+
+// fakeTimer FakeClock 的定时器
+type fakeTimer struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+// C 返回定时器的 channel
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+// Stop 停止定时器, 返回停止前是否处于活动状态
+func (t *fakeTimer) Stop() bool {
+	t.clock.Lock()
+	defer t.clock.Unlock()
+
+	active := t.active
+	t.active = false
+	return active
+}
+
+// Reset 重置定时器为 d 之后到期, 返回重置前是否处于活动状态
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.Lock()
+	active := t.active
+	t.active = true
+	t.deadline = t.clock.now.Add(d)
+	t.clock.Unlock()
+
+	// d <= 0 时立即触发
+	t.clock.fire()
+	return active
+}
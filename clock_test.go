@@ -0,0 +1,94 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockTimer(t *testing.T) {
+	c := NewFakeClock(time.Date(2019, 8, 1, 10, 0, 0, 0, time.UTC))
+	timer := c.NewTimer(10 * time.Second)
+
+	c.Advance(9 * time.Second)
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+
+	c.Advance(time.Second)
+	select {
+	case ts := <-timer.C():
+		if !ts.Equal(c.Now()) {
+			t.Fatalf("fired at %s, want %s", ts, c.Now())
+		}
+	default:
+		t.Fatal("timer not fired")
+	}
+
+	if timer.Reset(time.Second) {
+		t.Fatal("fired timer should not be active")
+	}
+	if !timer.Stop() {
+		t.Fatal("reset timer should be active")
+	}
+}
+
+// chanWriter 将每个周期的数据发送到 channel
+type chanWriter chan *OneMinStorage
+
+func (c chanWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) error {
+	c <- omd
+	return nil
+}
+
+func TestLoopWithFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 8, 1, 10, 0, 25, 0, time.UTC))
+	conf := NewConfig()
+	conf.Clock = clock
+
+	m, _ := New(conf)
+	out := make(chanWriter, 3)
+	m.writers = append(m.writers, out)
+	m.writerNames = append(m.writerNames, "chanWriter")
+
+	// 启动后第一个版本对齐到 10:00:00
+	m.Tick()
+	<-out
+
+	go m.loop()
+	defer m.Stop()
+	clock.BlockUntil(1)
+
+	m.Add("biz.count", 1)
+	clock.Advance(35 * time.Second)
+
+	omd := <-out
+	if want := time.Date(2019, 8, 1, 10, 0, 0, 0, time.UTC); !omd.Ts.Equal(want) {
+		t.Fatalf("Ts = %s, want %s", omd.Ts, want)
+	}
+	if v, _ := omd.Get("biz.count"); v != 1 {
+		t.Fatalf("biz.count = %v, want 1", v)
+	}
+	if want := time.Date(2019, 8, 1, 10, 1, 0, 0, time.UTC); !m.Core.NowMonitor.Ts.Equal(want) {
+		t.Fatalf("next Ts = %s, want %s", m.Core.NowMonitor.Ts, want)
+	}
+}
+
+func TestTickSync(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 8, 1, 10, 0, 0, 0, time.UTC))
+	conf := NewConfig()
+	conf.Clock = clock
+	conf.ValidateInterval(10 * time.Second)
+
+	m, _ := New(conf)
+	m.Add("biz.count", 2)
+
+	now := m.Tick()
+	if v, _ := now.Get("biz.count"); v != 2 {
+		t.Fatalf("biz.count = %v, want 2", v)
+	}
+	if v, _ := now.Get(SelfNextMonitorDuration); v != 0 {
+		t.Fatalf("duration with fake clock = %v, want 0", v)
+	}
+}
@@ -0,0 +1,60 @@
+package monitor
+
+// 采集函数在每次切换版本前执行, 用于将外部的状态 (如连接池的统计) 记录到即将切换出来的版本中
+
+// collector 已添加的采集函数
+type collector struct {
+	name    string
+	collect func()
+}
+
+// AddCollector 添加一个采集函数, 每次切换版本前执行, 同一周期内的各采集函数串行执行
+// name 已存在时替换原有的采集函数, 不会重复执行
+// 采集函数应尽快返回, panic 会被 recover 并记录, 不影响版本切换
+func (m *MONITOR) AddCollector(name string, collect func()) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.collectors = append(m.withoutCollector(name), collector{name: name, collect: collect})
+}
+
+// RemoveCollector 移除名为 name 的采集函数, 如采集的对象已关闭, 不存在时不处理
+func (m *MONITOR) RemoveCollector(name string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.collectors = m.withoutCollector(name)
+}
+
+// withoutCollector 返回去掉名为 name 的采集函数的副本, 需持有锁
+// 复制后修改, runCollectors 已取出的切片不受影响
+func (m *MONITOR) withoutCollector(name string) []collector {
+	collectors := make([]collector, 0, len(m.collectors)+1)
+	for _, c := range m.collectors {
+		if c.name != name {
+			collectors = append(collectors, c)
+		}
+	}
+	return collectors
+}
+
+// runCollectors 执行所有采集函数
+func (m *MONITOR) runCollectors() {
+	m.RLock()
+	collectors := m.collectors
+	m.RUnlock()
+
+	for _, c := range collectors {
+		m.runCollector(c)
+	}
+}
+
+// runCollector 执行一个采集函数并 recover
+func (m *MONITOR) runCollector(c collector) {
+	defer func() {
+		if p := recover(); p != nil {
+			Logger.Error("collector panic", LogKeyCollector, c.name, LogKeyPanic, p)
+		}
+	}()
+	c.collect()
+}
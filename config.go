@@ -20,6 +20,15 @@ type Config struct {
 	// WebPath 采用 web 方式访问的时候, 读取的文件的 path
 	// 默认为 text_writer 写出来的文本 ./go-monitor.txt
 	WebPath string
+
+	// Clock 时钟, 默认为系统时钟, 测试时可替换为 FakeClock
+	Clock Clock
+
+	// StatsdPort statsd 接收端的 udp 端口, 默认为 0 不启动
+	StatsdPort int
+
+	// Precision http 模块输出值保留的小数位数, 默认为 FullPrecision 不取整, 0 为取整到整数
+	Precision int
 }
 
 // NewConfig 返回一个 Config实例,及一些默认的配置
@@ -30,7 +39,18 @@ func NewConfig() *Config {
 		Port:      9999,
 		Writers:   make([]*WriterConfig, 0),
 		WebPath:   "./go-monitor.txt",
+		Clock:     RealClock,
+		Precision: DefaultPrecision,
+	}
+}
+
+// ValidatePrecision 校验 http 模块输出值的小数位数
+func (c *Config) ValidatePrecision(precision int) error {
+	if !validPrecision(precision) {
+		return &ErrMonitorConfig{Msg: PrecisionError}
 	}
+	c.Precision = precision
+	return nil
 }
 
 // ValidateRevisions 校验历史版本留存数量
@@ -71,6 +91,17 @@ func (c *Config) ValidateHTTPPort(port int) error {
 	}
 }
 
+// ValidateStatsdPort 校验 statsd 接收端的端口, 不合法时返回 ErrStatsdPort
+// 0 为不启动 statsd 接收端
+func (c *Config) ValidateStatsdPort(port int) error {
+	if port == 0 || (port > 1024 && port < 65535) {
+		c.StatsdPort = port
+		return nil
+	}
+
+	return &ErrStatsdPort{Port: port}
+}
+
 // ValidateWebPath 写入通过 web 的 last 方法访问时候文件的路径
 // 当给的 path 为空时, c.WebPath 不做更改
 func (c *Config) ValidateWebPath(path string) error {
@@ -0,0 +1,155 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 基于 context 的记录方法, 请求处理中通过 ContextWithTags 附加 tags, 如 route tenant
+// Track 记录耗时及结果, 结果作为 OutcomeTag 的 tag 值, 一行 defer 同时得到耗时及错误次数:
+//
+//	func query(ctx context.Context) (err error) {
+//		defer m.Track(ctx, "db.query")(&err)
+//		...
+//	}
+
+// OutcomeTag Track 记录结果的 tag 名
+const OutcomeTag = "outcome"
+
+// Track 记录的结果
+const (
+	OutcomeSuccess  = "success"  // 返回的 error 为 nil
+	OutcomeError    = "error"    // 返回了其它 error
+	OutcomeCanceled = "canceled" // 返回的 error 为 context.Canceled
+	OutcomeTimeout  = "timeout"  // 返回的 error 为 context.DeadlineExceeded
+)
+
+// ctxTagsKey context 中 tags 的 key
+type ctxTagsKey struct{}
+
+// ctxTags context 中的 tags, 创建后只读, key 为 writeTagsKey 生成的 k1=v1;k2=v2, 用于缓存查找
+type ctxTags struct {
+	tags map[string]string
+	key  string
+}
+
+// ContextWithTags 返回附加了 tags 的 context, 与 ctx 中已有的 tags 合并, 同名的以新的为准
+func ContextWithTags(ctx context.Context, tags map[string]string) context.Context {
+	merged := make(map[string]string, len(tags))
+	if parent, ok := ctx.Value(ctxTagsKey{}).(*ctxTags); ok {
+		for k, v := range parent.tags {
+			merged[k] = v
+		}
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+
+	var key strings.Builder
+	writeTagsKey(&key, merged)
+
+	return context.WithValue(ctx, ctxTagsKey{}, &ctxTags{tags: merged, key: key.String()})
+}
+
+// TagsFromContext 返回 context 中的 tags 的拷贝, 没有时返回 nil
+func TagsFromContext(ctx context.Context) map[string]string {
+	ct := tagsFromContext(ctx)
+	if ct == nil {
+		return nil
+	}
+	tags := make(map[string]string, len(ct.tags))
+	for k, v := range ct.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+// tagsFromContext 返回 context 中只读的 tags
+func tagsFromContext(ctx context.Context) *ctxTags {
+	if ctx == nil {
+		return nil
+	}
+	ct, _ := ctx.Value(ctxTagsKey{}).(*ctxTags)
+	return ct
+}
+
+// Outcome 返回 err 对应的结果
+func Outcome(err error) string {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, context.Canceled):
+		return OutcomeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return OutcomeTimeout
+	}
+	return OutcomeError
+}
+
+// Track 开始记录 name 的耗时, 返回的函数结束记录, 参数为返回的 error 的指针, 可以为 nil
+// 以 context 中的 tags 加上 OutcomeTag 注册 TimerMetric, _Count 即为各结果的次数
+// 返回的函数多次调用时只有第一次记录
+func (m *MONITOR) Track(ctx context.Context, name string) func(errp *error) {
+	tags, start := tagsFromContext(ctx), time.Now()
+
+	var finished int32
+	return func(errp *error) {
+		if !atomic.CompareAndSwapInt32(&finished, 0, 1) {
+			return
+		}
+		defer m.recoverRecord("Track")
+
+		var err error
+		if errp != nil {
+			err = *errp
+		}
+		if timer := m.trackTimer(name, tags, Outcome(err)); timer != nil {
+			timer.Since(start)
+		}
+	}
+}
+
+// trackKey Track 耗时指标缓存的 key, 查找时不需要拼接字符串
+type trackKey struct {
+	name, tags, outcome string
+}
+
+// trackTimer 返回 name tags 及 outcome 对应的耗时指标, 已注册的从 MONITOR 的缓存中读取
+func (m *MONITOR) trackTimer(name string, ct *ctxTags, outcome string) *MetricTimer {
+	key := trackKey{name: name, outcome: outcome}
+	if ct != nil {
+		key.tags = ct.key
+	}
+
+	m.trackMu.RLock()
+	timer, ok := m.trackTimers[key]
+	m.trackMu.RUnlock()
+	if ok {
+		return timer
+	}
+
+	tags := make(map[string]string)
+	if ct != nil {
+		for k, v := range ct.tags {
+			tags[k] = v
+		}
+	}
+	tags[OutcomeTag] = outcome
+
+	timer, err := m.RegisterTimer(name, "Track "+name, tags)
+	if err != nil {
+		Logger.Error("register track timer error", LogKeyMetric, name, LogKeyErr, err)
+		return nil
+	}
+
+	m.trackMu.Lock()
+	if m.trackTimers == nil {
+		m.trackTimers = make(map[trackKey]*MetricTimer)
+	}
+	m.trackTimers[key] = timer
+	m.trackMu.Unlock()
+	return timer
+}
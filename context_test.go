@@ -0,0 +1,163 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestContextWithTags(t *testing.T) {
+	if TagsFromContext(context.Background()) != nil {
+		t.Fatal("expect nil tags for empty context")
+	}
+
+	ctx := ContextWithTags(context.Background(), map[string]string{"route": "/users", "tenant": "a"})
+	ctx = ContextWithTags(ctx, map[string]string{"tenant": "b"})
+
+	want := map[string]string{"route": "/users", "tenant": "b"}
+	tags := TagsFromContext(ctx)
+	if !reflect.DeepEqual(tags, want) {
+		t.Fatalf("tags %v, want %v", tags, want)
+	}
+	// 返回的是拷贝, 修改不影响 context
+	tags["route"] = "changed"
+	if got := TagsFromContext(ctx)["route"]; got != "/users" {
+		t.Fatalf("context tags changed to %s", got)
+	}
+	if got := tagsFromContext(ctx).key; got != "route=/users;tenant=b" {
+		t.Fatalf("tags key %s", got)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	cases := map[error]string{
+		nil:                OutcomeSuccess,
+		errors.New("boom"): OutcomeError,
+		context.Canceled:   OutcomeCanceled,
+		fmt.Errorf("query: %w", context.DeadlineExceeded): OutcomeTimeout,
+	}
+	for err, want := range cases {
+		if got := Outcome(err); got != want {
+			t.Fatalf("outcome of %v is %s, want %s", err, got, want)
+		}
+	}
+}
+
+func trackQuery(m *MONITOR, ctx context.Context, fail bool) (err error) {
+	defer m.Track(ctx, "db.query")(&err)
+	if fail {
+		return errors.New("query failed")
+	}
+	return nil
+}
+
+func TestTrack(t *testing.T) {
+	m, _ := New(NewConfig())
+	ctx := ContextWithTags(context.Background(), map[string]string{"tenant": "a"})
+
+	for i := 0; i < 3; i++ {
+		trackQuery(m, ctx, false)
+	}
+	trackQuery(m, ctx, true)
+	m.Track(context.Background(), "db.query")(nil)
+	omd := m.Core.NextMonitor()
+
+	counts := make(map[string]float64)
+	for _, p := range collectPoints(m.Core.MetricMap, omd) {
+		if p.Name != "db.query" {
+			continue
+		}
+		if p.Type != TimerMetric || p.Unit != "ms" {
+			t.Fatalf("unexpected track point %+v", p)
+		}
+		count, _ := p.Field("Count")
+		counts[p.Tags["tenant"]+"/"+p.Tags[OutcomeTag]] = count
+	}
+	want := map[string]float64{"a/success": 3, "a/error": 1, "/success": 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Fatalf("track counts %v, want %v", counts, want)
+	}
+}
+
+func TestTrackAllocs(t *testing.T) {
+	m, _ := New(NewConfig())
+	ctx := ContextWithTags(context.Background(), map[string]string{"tenant": "a"})
+	trackQuery(m, ctx, false)
+
+	// 已注册后缓存查找不分配, 只有返回的函数及其状态
+	var err error
+	allocs := testing.AllocsPerRun(100, func() {
+		m.Track(ctx, "db.query")(&err)
+	})
+	if allocs > 2 {
+		t.Fatalf("track allocs %v, want <= 2", allocs)
+	}
+}
+
+func TestTrackDoneTwice(t *testing.T) {
+	m, _ := New(NewConfig())
+	ctx := context.Background()
+
+	// 重复调用只记录一次, 也不影响之后的 Track
+	done := m.Track(ctx, "db.query")
+	done(nil)
+	done(nil)
+	other := m.Track(ctx, "db.query")
+	done(nil)
+	other(nil)
+	omd := m.Core.NextMonitor()
+
+	for _, p := range collectPoints(m.Core.MetricMap, omd) {
+		if p.Name != "db.query" {
+			continue
+		}
+		if count, _ := p.Field("Count"); count != 2 {
+			t.Fatalf("track count %v, want 2", count)
+		}
+	}
+}
+
+func TestContextTagsKeyEscape(t *testing.T) {
+	a := ContextWithTags(context.Background(), map[string]string{"a": "1;b=2"})
+	b := ContextWithTags(context.Background(), map[string]string{"a": "1", "b": "2"})
+	if ka, kb := tagsFromContext(a).key, tagsFromContext(b).key; ka == kb {
+		t.Fatalf("tags key %s collides", ka)
+	}
+
+	m, _ := New(NewConfig())
+	trackQuery(m, a, false)
+	trackQuery(m, b, false)
+	omd := m.Core.NextMonitor()
+
+	n := 0
+	for _, p := range collectPoints(m.Core.MetricMap, omd) {
+		if p.Name == "db.query" {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Fatalf("got %d track series, want 2", n)
+	}
+}
+
+func TestMetricKeyEscape(t *testing.T) {
+	keys := map[string]bool{}
+	for _, k := range []string{
+		metricKey("x", map[string]string{"a": "1;b=2"}),
+		metricKey("x", map[string]string{"a": "1", "b": "2"}),
+		metricKey("x;a=1", nil),
+		metricKey("x", map[string]string{"a": "1"}),
+		metricKey("x", map[string]string{"a=1": ""}),
+		metricKey("x", map[string]string{"a": "=1"}),
+	} {
+		if keys[k] {
+			t.Fatalf("metric key %s collides", k)
+		}
+		keys[k] = true
+	}
+	if got := metricKey("db.query", nil); got != "db.query" {
+		t.Fatalf("metric key %s, want db.query", got)
+	}
+}
@@ -0,0 +1,101 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/csv"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 追加写入 CSV 文件的 writer, 每个值一行, 保留每个周期的数据
+// 文件按 WriterConfig 的 MaxFileSize RotateInterval MaxBackups 轮转, 新文件以表头开始
+
+// init 注册一个初始化 CSVWriter 的 Writer
+func init() {
+	f := func(conf *WriterConfig) Writer {
+		return &CSVWriter{
+			Conf:     conf,
+			Describe: "A append only CSV file writer",
+			file:     newRotateFile(conf),
+		}
+	}
+
+	RegisterWriterName["CSVWriter"] = f
+}
+
+var (
+	// CSVHeader CSV 文件的表头
+	CSVHeader = []string{"ts", "host", "name", "tags", "field", "value"}
+)
+
+// CSVWriter CSV 文件的 writer
+type CSVWriter struct {
+	Conf     *WriterConfig
+	Describe string
+
+	file *rotateFile
+}
+
+// DoWithRecover 处理一分钟的数据
+func (j *CSVWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			Logger.Error("writer panic at DoWithRecover", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyPanic, p)
+			err = &ErrWriterPanic{Name: j.Conf.Name, Panic: p}
+		}
+	}()
+
+	var header, buf bytes.Buffer
+	if err = writeCSVRows(&header, [][]string{CSVHeader}); err == nil {
+		err = writeCSVRows(&buf, csvRows(writerPoints(j.Conf, nameMap, omd), omd.Ts.Unix()))
+	}
+	if err != nil {
+		Logger.Error("writer format error", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+		return err
+	}
+
+	if err = j.file.Write(buf.Bytes(), header.Bytes(), omd.Ts); err != nil {
+		Logger.Error("writer write file error", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+	}
+	return
+}
+
+// Close 关闭正在写入的文件, Monitor Stop 时调用
+func (j *CSVWriter) Close() error {
+	return j.file.Close()
+}
+
+// csvRows 将 Point 列表转换为 CSV 的行, tags 格式为 k1=v1;k2=v2
+func csvRows(points []*Point, ts int64) [][]string {
+	tsStr := strconv.FormatInt(ts, 10)
+
+	rows := make([][]string, 0, len(points))
+	for _, p := range points {
+		pairs := make([]string, 0, len(p.Tags))
+		for _, k := range p.SortedTagKeys() {
+			pairs = append(pairs, k+string(Equal)+p.Tags[k])
+		}
+		tags := strings.Join(pairs, string(Semicolon))
+
+		for i, value := range p.Values {
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			rows = append(rows, []string{
+				tsStr, HostName, p.Name, tags, p.FieldName(i),
+				strconv.FormatFloat(value, 'g', -1, 64),
+			})
+		}
+	}
+	return rows
+}
+
+// writeCSVRows 写入 CSV 的行
+func writeCSVRows(buf *bytes.Buffer, rows [][]string) error {
+	w := csv.NewWriter(buf)
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return w.Error()
+}
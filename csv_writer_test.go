@@ -0,0 +1,32 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCSVWriterAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitor.csv")
+	conf := NewWriterConfig()
+	conf.ValidWriterName("CSVWriter")
+	conf.DownPath = path
+
+	w, _ := InitWriter(conf)
+	for i := 0; i < 2; i++ {
+		if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+			t.Fatalf("csv writer error %s", err)
+		}
+	}
+	w.(*CSVWriter).file.Close()
+
+	b, _ := ioutil.ReadFile(path)
+	got := string(b)
+	if !strings.HasPrefix(got, "ts,host,name,tags,field,value\n") || strings.Count(got, "ts,host") != 1 {
+		t.Fatalf("header missing or repeated: %s", got)
+	}
+	if row := "1564617600," + HostName + ",api latency,route=/users,Avg,20\n"; strings.Count(got, row) != 2 {
+		t.Fatalf("row %q should appear twice: %s", row, got)
+	}
+}
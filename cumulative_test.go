@@ -0,0 +1,105 @@
+package monitor
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCumulativeMetric(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 8, 1, 10, 0, 0, 0, time.UTC))
+	conf := NewConfig()
+	conf.Clock = clock
+	m, _ := New(conf)
+
+	id, err := m.RegisterMetric("rpc.calls", CountSumMetric, "rpc calls", nil, WithCumulative())
+	if err != nil {
+		t.Fatalf("register error %s", err)
+	}
+	if _, err := m.RegisterMetric("rpc.depth", GaugeMetric, "", nil, WithCumulative()); err == nil {
+		t.Fatal("expect cumulative option error for GaugeMetric")
+	}
+
+	want := []string{"_Count", "_Sum", "_CountTotal", "_SumTotal"}
+	if got := m.Core.MetricMap.Map[id].Suffixes(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("suffixes %v, want %v", got, want)
+	}
+
+	m.AddPersistent(id, CountSumMetric, 2)
+	m.AddPersistent(id, CountSumMetric, 3)
+	omd := m.Core.NextMonitor()
+	if got := getValues(m.Core.MetricMap.Map[id], omd.PersistentData[id]); !reflect.DeepEqual(got, []float64{2, 5, 2, 5}) {
+		t.Fatalf("first interval values %v", got)
+	}
+
+	// 没有记录的周期增量为 0, 累计值保持不变
+	omd = m.Core.NextMonitor()
+	if got := getValues(m.Core.MetricMap.Map[id], omd.PersistentData[id]); !reflect.DeepEqual(got, []float64{0, 0, 2, 5}) {
+		t.Fatalf("empty interval values %v", got)
+	}
+
+	m.AddPersistent(id, CountSumMetric, 10)
+	omd = m.Core.NextMonitor()
+	if got := getValues(m.Core.MetricMap.Map[id], omd.PersistentData[id]); !reflect.DeepEqual(got, []float64{1, 10, 3, 15}) {
+		t.Fatalf("third interval values %v", got)
+	}
+
+	var p *Point
+	for _, point := range collectPoints(m.Core.MetricMap, omd) {
+		if point.Name == "rpc.calls" {
+			p = point
+		}
+	}
+	if p == nil || !p.Start.Equal(clock.Now()) || p.IsTotal(1) || !p.IsTotal(2) {
+		t.Fatalf("unexpected cumulative point %+v", p)
+	}
+
+	var buf bytes.Buffer
+	if err := (&PrometheusFormatter{}).Format(&buf, &Snapshot{Ts: omd.Ts, Points: []*Point{p}}); err != nil {
+		t.Fatalf("prometheus format error %s", err)
+	}
+	for _, line := range []string{
+		"# TYPE rpc_calls_CountTotal counter\nrpc_calls_CountTotal 3\n",
+		"# TYPE rpc_calls_SumTotal counter\nrpc_calls_SumTotal 15\n",
+		"# TYPE rpc_calls_Count gauge\nrpc_calls_Count 1\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatalf("prometheus output missing %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestCumulativeRestart(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 8, 1, 10, 0, 0, 0, time.UTC))
+	conf := NewConfig()
+	conf.Clock = clock
+	m, _ := New(conf)
+	id, _ := m.RegisterMetric("jobs", QuantileMetric, "", nil, WithCumulative())
+	m.AddPersistent(id, QuantileMetric, 4)
+	first := collectPoints(m.Core.MetricMap, m.Core.NextMonitor())
+
+	// 重启后累计值从 0 开始, 开始时间变化
+	clock.Advance(time.Hour)
+	m, _ = New(conf)
+	id, _ = m.RegisterMetric("jobs", QuantileMetric, "", nil, WithCumulative())
+	m.AddPersistent(id, QuantileMetric, 1)
+	second := collectPoints(m.Core.MetricMap, m.Core.NextMonitor())
+
+	total := func(points []*Point) (*Point, float64) {
+		for _, p := range points {
+			if p.Name == "jobs" {
+				v, _ := p.Field("SumTotal")
+				return p, v
+			}
+		}
+		t.Fatal("point jobs not found")
+		return nil, 0
+	}
+	p1, v1 := total(first)
+	p2, v2 := total(second)
+	if v1 != 4 || v2 != 1 || !p2.Start.After(p1.Start) {
+		t.Fatalf("restart totals %v %v, start %v %v", v1, v2, p1.Start, p2.Start)
+	}
+}
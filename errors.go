@@ -65,3 +65,44 @@ type ErrHTTPPort struct{}
 func (e *ErrHTTPPort) Error() string {
 	return "Http Port Must be >1024 and  < 65535"
 }
+
+// ErrStatsdPort statsd 接收端端口错误
+type ErrStatsdPort struct {
+	Port int // 传入的端口
+}
+
+func (e *ErrStatsdPort) Error() string {
+	return fmt.Sprintf("Statsd Port %d error, must be >1024 and < 65535", e.Port)
+}
+
+// ErrWriterPanic Writer 执行过程中发生 panic, 已被 recover
+type ErrWriterPanic struct {
+	Name  string      // Writer 名
+	Panic interface{} // recover 得到的值
+}
+
+// Error 实现 error 接口
+func (e *ErrWriterPanic) Error() string {
+	return fmt.Sprintf("Writer %s panic: %v", e.Name, e.Panic)
+}
+
+// ErrHyperLogLog HyperLogLog 的精度不合法, 合并时精度不一致或反序列化的数据错误
+type ErrHyperLogLog struct {
+	Msg string
+}
+
+// Error 实现 error 接口
+func (e *ErrHyperLogLog) Error() string {
+	return fmt.Sprintf("HyperLogLog error: %s", e.Msg)
+}
+
+// ErrMetricConflict 同一 key 已注册的指标与本次注册的类型或配置不一致
+type ErrMetricConflict struct {
+	Name string // 指标名
+	Msg  string // 不一致的原因
+}
+
+// Error 实现 error 接口
+func (e *ErrMetricConflict) Error() string {
+	return fmt.Sprintf("Metric %s conflict: %s", e.Name, e.Msg)
+}
@@ -24,7 +24,7 @@ func init() {
 	var err error
 	M, err = New(cfgMonitor)
 	if err != nil {
-		Logger.Printf("Start Monitor Error : %s", err)
+		Logger.Error("start monitor error", LogKeyErr, err)
 	}
 }
 
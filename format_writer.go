@@ -0,0 +1,74 @@
+package monitor
+
+import (
+	"bytes"
+)
+
+// 由 Formatter 和 Transport 组合的 writer
+// 格式由 WriterConfig 的 Format 指定, 默认为 text; 发送方式由 Transport 指定, 默认为 file
+
+// init 注册一个初始化 FormatWriter 的 Writer
+func init() {
+	f := func(conf *WriterConfig) Writer {
+		return &FormatWriter{
+			Conf:     conf,
+			Describe: "A Formatter and Transport composed writer",
+		}
+	}
+
+	RegisterWriterName["FormatWriter"] = f
+}
+
+// FormatWriter 组合 Formatter 和 Transport 的 writer
+type FormatWriter struct {
+	Conf     *WriterConfig
+	Describe string
+}
+
+// DoWithRecover 处理一分钟的数据
+func (j *FormatWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			Logger.Error("writer panic at DoWithRecover", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyPanic, p)
+			err = &ErrWriterPanic{Name: j.Conf.Name, Panic: p}
+		}
+	}()
+
+	return writeFormatted(j.Conf, nameMap, omd, TextFormat, FileTransport)
+}
+
+// writeFormatted 按配置的 Format 格式化一个周期的数据并通过 Transport 发送
+// 配置为空时使用 format 及 transport 指定的默认值
+func writeFormatted(conf *WriterConfig, nameMap *MetricNameMap, omd *OneMinStorage, format, transport string) error {
+	if conf.Format != "" {
+		format = conf.Format
+	}
+	if conf.Transport != "" {
+		transport = conf.Transport
+	}
+
+	formatter, err := InitFormatter(format, conf)
+	if err != nil {
+		Logger.Error("writer init formatter error", LogKeyWriter, conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+		return err
+	}
+	t, err := InitTransport(transport, conf)
+	if err != nil {
+		Logger.Error("writer init transport error", LogKeyWriter, conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err = formatter.Format(&buf, NewSnapshot(nameMap, omd).Round(conf.Precision)); err != nil {
+		Logger.Error("writer format error", LogKeyWriter, conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+		return err
+	}
+
+	err = uploadWithRetry(conf.UploadRetry, func() error {
+		return t.Send(buf.Bytes(), formatter.ContentType())
+	})
+	if err != nil {
+		Logger.Error("writer transport error", LogKeyWriter, conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+	}
+	return err
+}
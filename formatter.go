@@ -0,0 +1,155 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Formatter 负责将一个周期的数据格式化, 与数据的最终处理方式 Transport 分离
+// 通过 WriterConfig 的 Format 和 Transport 组合, 也可由 HTTP 模块通过 format 参数直接输出
+
+// Snapshot 一个周期的数据快照, Formatter 的输入
+type Snapshot struct {
+	Ts     time.Time // 周期开始时间
+	End    time.Time // 周期结束时间, 当前周期为零值
+	Points []*Point  // 所有指标
+}
+
+// NewSnapshot 返回一个周期数据的快照
+func NewSnapshot(nameMap *MetricNameMap, omd *OneMinStorage) *Snapshot {
+	points := collectPoints(nameMap, omd)
+
+	omd.RLock()
+	defer omd.RUnlock()
+	return &Snapshot{Ts: omd.Ts, End: omd.End, Points: points}
+}
+
+// Round 将快照中的值按 precision 位小数四舍五入, precision 为 FullPrecision 时不处理
+func (s *Snapshot) Round(precision int) *Snapshot {
+	roundPoints(s.Points, precision)
+	return s
+}
+
+// Formatter 格式化接口
+type Formatter interface {
+	Format(w io.Writer, snap *Snapshot) error // 将快照格式化输出到 w
+	ContentType() string                      // 格式化结果的 MIME 类型, 用于 HTTP
+}
+
+// RegisterFormatterName 存储已注册的 Formatter Name
+var RegisterFormatterName = map[string]func(conf *WriterConfig) Formatter{}
+
+// 内置的 Formatter 名
+const (
+	// TextFormat 与 TextWriter 相同的文本格式
+	TextFormat = "text"
+	// JSONFormat JSON Lines 格式
+	JSONFormat = "json"
+	// PrometheusFormat Prometheus 文本格式
+	PrometheusFormat = "prometheus"
+	// InfluxFormat InfluxDB line protocol
+	InfluxFormat = "influx"
+	// GraphiteFormat Graphite 的 plaintext 格式
+	GraphiteFormat = "graphite"
+)
+
+// init 注册 text 及 json 格式
+func init() {
+	RegisterFormatterName[TextFormat] = func(conf *WriterConfig) Formatter {
+		return newTextFormatter(conf)
+	}
+	RegisterFormatterName[JSONFormat] = func(conf *WriterConfig) Formatter {
+		return &JSONFormatter{}
+	}
+}
+
+// InitFormatter 根据名字初始化一个 Formatter
+func InitFormatter(name string, conf *WriterConfig) (Formatter, error) {
+	if f, ok := RegisterFormatterName[name]; ok {
+		return f(conf), nil
+	}
+	return nil, &ErrorWriterConfig{Msg: fmt.Sprintf("Can't find Formatter %s", name)}
+}
+
+// TextFormatter 文本格式, 第一行为时间戳, 空行之后每行一个 指标名后缀;tags=值
+type TextFormatter struct {
+	Precision int // 小数位数, 为 FullPrecision 时使用最短的完整精度表示
+}
+
+// newTextFormatter 根据 writer 配置返回文本格式, 精度与其它格式相同
+func newTextFormatter(conf *WriterConfig) *TextFormatter {
+	return &TextFormatter{Precision: conf.Precision}
+}
+
+// ContentType 实现 Formatter 接口
+func (f *TextFormatter) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+// Format 实现 Formatter 接口
+func (f *TextFormatter) Format(w io.Writer, snap *Snapshot) error {
+	var buf bytes.Buffer
+	buf.WriteString(strconv.FormatInt(snap.Ts.Unix(), 10))
+	buf.Write(NewLineBytes)
+
+	// 特殊监控值在前, 普通监控值在后, 各自按指标名及 tags 排序
+	for _, p := range snap.Points {
+		if p.Plain {
+			continue
+		}
+
+		tags := textTags(p)
+		for i, value := range p.Values {
+			fmt.Fprintf(&buf, "%s%s%s=%s\n", p.Name, p.Suffix[i], tags, f.formatValue(p.FieldName(i), value))
+		}
+	}
+	for _, p := range snap.Points {
+		if p.Plain {
+			fmt.Fprintf(&buf, "%s=%s\n", p.Name, formatFloat(p.Values[0], f.Precision))
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// textTags 返回文本格式的 tags, 格式为 ;k1=v1;k2=v2
+func textTags(p *Point) string {
+	var buf bytes.Buffer
+	for _, k := range p.SortedTagKeys() {
+		buf.WriteByte(Semicolon)
+		buf.WriteString(k)
+		buf.WriteByte(Equal)
+		buf.WriteString(p.Tags[k])
+	}
+	return buf.String()
+}
+
+// formatValue 格式化特殊监控的值, Count 及 CountTotal 为整数, 其余按 Precision 格式化
+func (f *TextFormatter) formatValue(field string, value float64) string {
+	if field == "Count" || field == "CountTotal" {
+		return strconv.FormatInt(int64(value), 10)
+	}
+	return formatFloat(value, f.Precision)
+}
+
+// JSONFormatter JSON Lines 格式, 与 JSONLinesWriter 相同
+type JSONFormatter struct{}
+
+// ContentType 实现 Formatter 接口
+func (f *JSONFormatter) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// Format 实现 Formatter 接口
+func (f *JSONFormatter) Format(w io.Writer, snap *Snapshot) error {
+	var buf bytes.Buffer
+	if err := writeJSONLines(&buf, snap.Points, snap.Ts.Unix()); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
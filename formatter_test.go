@@ -0,0 +1,321 @@
+package monitor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func formatString(t *testing.T, format string, snap *Snapshot) string {
+	f, err := InitFormatter(format, NewWriterConfig())
+	if err != nil {
+		t.Fatalf("init formatter %s error %s", format, err)
+	}
+	var buf bytes.Buffer
+	if err = f.Format(&buf, snap); err != nil {
+		t.Fatalf("format %s error %s", format, err)
+	}
+	return buf.String()
+}
+
+// pointValue 返回指定指标名及 tags 的 Point 的 field 值
+func pointValue(t *testing.T, points []*Point, name string, tags map[string]string, field string) float64 {
+	key := metricKey(name, tags)
+	for _, p := range points {
+		if metricKey(p.Name, p.Tags) == key {
+			v, _ := p.Field(field)
+			return v
+		}
+	}
+	t.Fatalf("point %s %v not found", name, tags)
+	return 0
+}
+
+func TestTextFormatter(t *testing.T) {
+	snap := NewSnapshot(newTestStorage(t))
+
+	want := "1564617600\n\n" +
+		"api latency_Count;route=/users=2\n" +
+		"api latency_Avg;route=/users=20\n" +
+		"biz.count=3\n"
+	if got := formatString(t, TextFormat, snap); got != want {
+		t.Fatalf("text format\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatterSortedOutput(t *testing.T) {
+	m, _ := New(NewConfig())
+	for _, name := range []string{"c.metric", "a.metric", "b.metric"} {
+		for _, route := range []string{"/z", "/a", "/m"} {
+			id, _ := m.RegisterMetric(name, CountMetric, "", map[string]string{"route": route})
+			m.AddPersistent(id, CountMetric, 1)
+		}
+		m.Add("plain."+name, 1)
+	}
+	omd := m.Core.NextMonitor()
+	for k := range omd.Data {
+		if strings.HasPrefix(k, SelfMonitorKey) {
+			delete(omd.Data, k)
+		}
+	}
+
+	want := formatString(t, TextFormat, NewSnapshot(m.Core.MetricMap, omd))
+	lines := strings.Split(strings.TrimSpace(want), "\n")[2:]
+	expect := []string{
+		"a.metric_Count;route=/a=1", "a.metric_Count;route=/m=1", "a.metric_Count;route=/z=1",
+		"b.metric_Count;route=/a=1", "b.metric_Count;route=/m=1", "b.metric_Count;route=/z=1",
+		"c.metric_Count;route=/a=1", "c.metric_Count;route=/m=1", "c.metric_Count;route=/z=1",
+		"plain.a.metric=1", "plain.b.metric=1", "plain.c.metric=1",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expect, "\n") {
+		t.Fatalf("unsorted output:\n%s", want)
+	}
+
+	// 多次格式化的结果一致
+	for i := 0; i < 10; i++ {
+		if got := formatString(t, TextFormat, NewSnapshot(m.Core.MetricMap, omd)); got != want {
+			t.Fatalf("output changed between runs:\n%s\n%s", got, want)
+		}
+	}
+}
+
+func TestFormatterPrecision(t *testing.T) {
+	m, _ := New(NewConfig())
+	id, _ := m.RegisterMetric("latency", AvgMetric, "", nil)
+	m.AddPersistent(id, AvgMetric, 1)
+	m.AddPersistent(id, AvgMetric, 1.0/3*2)
+	m.Set("ratio", 2.0/3)
+	m.Set("big", 1000000)
+	m.Set("small", 0.0000001)
+	omd := m.Core.NextMonitor()
+	omd.Ts = time.Unix(1564617600, 0)
+
+	conf := NewWriterConfig()
+	if conf.ValidatePrecision(-2) == nil || conf.ValidatePrecision(16) == nil {
+		t.Fatalf("expect precision error")
+	}
+	conf.ValidatePrecision(2)
+
+	snap := NewSnapshot(m.Core.MetricMap, omd).Round(conf.Precision)
+	var text, influx bytes.Buffer
+	RegisterFormatterName[TextFormat](conf).Format(&text, snap)
+	RegisterFormatterName[InfluxFormat](conf).Format(&influx, snap)
+
+	if !strings.Contains(text.String(), "latency_Avg=0.83\n") || !strings.Contains(text.String(), "ratio=0.67\n") {
+		t.Fatalf("unexpected text with precision 2:\n%s", text.String())
+	}
+	if !strings.Contains(influx.String(), "latency,host="+influxTagEscaper.Replace(HostName)+" Avg=0.83 ") {
+		t.Fatalf("unexpected influx with precision 2:\n%s", influx.String())
+	}
+
+	// 默认不取整, 文本与其它格式相同使用完整精度, 不使用指数
+	snap = NewSnapshot(m.Core.MetricMap, omd)
+	got := formatString(t, TextFormat, snap)
+	for _, line := range []string{"latency_Avg=0.8333333333333333\n", "big=1000000\n", "small=0.0000001\n"} {
+		if !strings.Contains(got, line) {
+			t.Fatalf("missing %q in default text:\n%s", line, got)
+		}
+	}
+	if got := formatString(t, InfluxFormat, snap); !strings.Contains(got, " Avg=0.8333333333333333 ") {
+		t.Fatalf("unexpected default influx:\n%s", got)
+	}
+
+	// 0 为取整到整数
+	if err := conf.ValidatePrecision(0); err != nil {
+		t.Fatalf("zero decimals error %s", err)
+	}
+	snap = NewSnapshot(m.Core.MetricMap, omd).Round(conf.Precision)
+	text.Reset()
+	RegisterFormatterName[TextFormat](conf).Format(&text, snap)
+	if !strings.Contains(text.String(), "latency_Avg=1\n") || !strings.Contains(text.String(), "ratio=1\n") {
+		t.Fatalf("unexpected text with zero decimals:\n%s", text.String())
+	}
+}
+
+func TestPrometheusFormatter(t *testing.T) {
+	m, _ := New(NewConfig())
+	for _, route := range []string{"/a", "/b"} {
+		id, _ := m.RegisterMetric("http.calls", CountMetric, "http \"calls\"", map[string]string{"route": route})
+		m.AddPersistent(id, CountMetric, 1)
+	}
+	qid, _ := m.RegisterMetric("rpc.latency", QuantileMetric, "", map[string]string{"0ne": "x\"y"})
+	for i := 1; i <= 100; i++ {
+		m.AddPersistent(qid, QuantileMetric, float64(i))
+	}
+	snap := NewSnapshot(m.Core.MetricMap, m.Core.NextMonitor())
+
+	got := formatString(t, PrometheusFormat, snap)
+	if strings.Contains(got, "summary") {
+		t.Fatalf("per-interval quantiles must not be summary:\n%s", got)
+	}
+	if strings.Count(got, "# TYPE http_calls_Count gauge\n") != 1 ||
+		strings.Count(got, "# HELP http_calls_Count http \"calls\"\n") != 1 {
+		t.Fatalf("http_calls_Count family not merged:\n%s", got)
+	}
+	for _, line := range []string{
+		`http_calls_Count{route="/a"} 1`,
+		`http_calls_Count{route="/b"} 1`,
+		"# TYPE rpc_latency gauge",
+		"# TYPE rpc_latency_sum gauge",
+		"# TYPE rpc_latency_count gauge",
+		`rpc_latency{_0ne="x\"y",quantile="0.5"} `,
+		`rpc_latency_sum{_0ne="x\"y"} 5050`,
+		`rpc_latency_count{_0ne="x\"y"} 100`,
+	} {
+		if !strings.Contains(got, line) {
+			t.Fatalf("missing %q in\n%s", line, got)
+		}
+	}
+}
+
+func TestGraphiteFormatter(t *testing.T) {
+	snap := NewSnapshot(newTestStorage(t))
+
+	got := formatString(t, GraphiteFormat, snap)
+	host := sanitizeGraphite(HostName, false)
+	for _, line := range []string{
+		"api_latency.host." + host + ".route._users.Count 2 1564617600\n",
+		"api_latency.host." + host + ".route._users.Avg 20 1564617600\n",
+		"biz.count.host." + host + " 3 1564617600\n",
+	} {
+		if !strings.Contains(got, line) {
+			t.Fatalf("missing %q in\n%s", line, got)
+		}
+	}
+}
+
+func TestInitFormatterNotFound(t *testing.T) {
+	if _, err := InitFormatter("xml", NewWriterConfig()); err == nil {
+		t.Fatalf("expect error for unknown formatter")
+	}
+	conf := NewWriterConfig()
+	if conf.ValidateFormat("xml") == nil || conf.ValidateTransport("ftp") == nil {
+		t.Fatalf("expect validate error")
+	}
+	if conf.ValidateFormat(PrometheusFormat) != nil || conf.ValidateTransport(HTTPProtocol) != nil {
+		t.Fatalf("expect registered format and transport valid")
+	}
+}
+
+func TestFormatWriterHTTPTransport(t *testing.T) {
+	var body, contentType, path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, contentType, path = string(b), r.Header.Get("Content-Type"), r.URL.Path
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	conf := NewWriterConfig()
+	conf.ValidWriterName("FormatWriter")
+	conf.ValidateMode(UP)
+	conf.UpLoadHost = u.Hostname()
+	conf.ValidateUpLoadPort(port)
+	conf.ValidateUploadPath("/ingest")
+	conf.ValidateFormat(InfluxFormat)
+	conf.ValidateTransport(HTTPProtocol)
+
+	w, _ := InitWriter(conf)
+	if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+		t.Fatalf("format writer error %s", err)
+	}
+
+	if path != "/ingest" || contentType != "text/plain; charset=utf-8" {
+		t.Fatalf("path %q, content type %q", path, contentType)
+	}
+	if !strings.Contains(body, "api\\ latency,host=") || !strings.Contains(body, "Count=2,Avg=20 1564617600000000000\n") {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
+
+func TestFormatWriterFileTransport(t *testing.T) {
+	conf := NewWriterConfig()
+	conf.ValidWriterName("FormatWriter")
+	conf.DownPath = filepath.Join(t.TempDir(), "metrics.json")
+	conf.ValidateFormat(JSONFormat)
+
+	w, _ := InitWriter(conf)
+	if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+		t.Fatalf("format writer error %s", err)
+	}
+
+	b, _ := ioutil.ReadFile(conf.DownPath)
+	if lines := strings.Count(string(b), "\n"); lines != 2 {
+		t.Fatalf("expect 2 json lines, got %d:\n%s", lines, b)
+	}
+}
+
+func TestHandleFormat(t *testing.T) {
+	m, _ := New(NewConfig())
+	m.Set("queue.depth", 5)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		m.HandleFormat(rec, httptest.NewRequest("GET", "/metrics?"+query, nil))
+		return rec
+	}
+
+	// 还没有已完成的周期
+	if rec := get("format=prometheus"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expect 404 before first rotation, got %d", rec.Code)
+	}
+
+	rec := get("format=prometheus&version=current")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "queue_depth 5\n") {
+		t.Fatalf("current: %d %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	omd := m.Core.NextMonitor()
+	omd.Ts = time.Unix(1564617600, 0)
+	rec = get("")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "1564617600\n\n") {
+		t.Fatalf("last: %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := get("format=xml"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expect 400 for unknown format, got %d", rec.Code)
+	}
+	if rec := get("version=abc"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expect 400 for bad version, got %d", rec.Code)
+	}
+}
+
+func TestHandleMonitorPrecision(t *testing.T) {
+	conf := NewConfig()
+	conf.ValidatePrecision(2)
+	m, _ := New(conf)
+	id, _ := m.RegisterMetric("latency", AvgMetric, "", nil)
+	m.AddPersistent(id, AvgMetric, 2.0/3)
+	m.Set("ratio", 1.0/3)
+
+	get := func(handle http.HandlerFunc, vars map[string]string) string {
+		rec := httptest.NewRecorder()
+		handle(rec, mux.SetURLVars(httptest.NewRequest("GET", "/", nil), vars))
+		return rec.Body.String()
+	}
+	if got := get(m.HandleMonitor, map[string]string{"metric": "latency"}); !strings.Contains(got, "Sum: 0.67\n") {
+		t.Fatalf("monitor spec value without precision:\n%s", got)
+	}
+	if got := get(m.HandleMonitor, map[string]string{"metric": "ratio"}); !strings.Contains(got, "ratio : 0.33") {
+		t.Fatalf("monitor value without precision:\n%s", got)
+	}
+
+	m.Core.NextMonitor()
+	if got := get(m.HandleHistory, map[string]string{"metric": "latency", "HVersion": "1"}); !strings.Contains(got, "Sum: 0.67\n") {
+		t.Fatalf("history spec value without precision:\n%s", got)
+	}
+}
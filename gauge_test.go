@@ -0,0 +1,102 @@
+package monitor
+
+import (
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// gaugeValues 返回 gauge 指标在 omd 中的 Last Min Max Mean
+func gaugeValues(m *MONITOR, id int, omd *OneMinStorage) []float64 {
+	return getValues(m.Core.MetricMap.Map[id], omd.PersistentData[id])
+}
+
+func TestGaugeMetric(t *testing.T) {
+	m, _ := New(NewConfig())
+	id, err := m.RegisterMetric("queue.depth", GaugeMetric, "queue depth", map[string]string{"queue": "jobs"})
+	if err != nil {
+		t.Fatalf("register error %s", err)
+	}
+
+	// 还没有取值时均为 NaN, 且不延续
+	omd := m.Core.NextMonitor()
+	for _, v := range gaugeValues(m, id, omd) {
+		if !math.IsNaN(v) {
+			t.Fatalf("expect NaN for empty gauge, got %v", gaugeValues(m, id, omd))
+		}
+	}
+
+	for _, v := range []float64{5, 1, 9} {
+		m.SetPersistent(id, GaugeMetric, v)
+	}
+	m.AddPersistent(id, GaugeMetric, -3) // 9 - 3 = 6
+	omd = m.Core.NextMonitor()
+	if got, want := gaugeValues(m, id, omd), []float64{6, 1, 9, 21.0 / 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("gauge values %v, want %v", got, want)
+	}
+
+	// 没有新的取值时, 最后值延续到下一个周期
+	omd = m.Core.NextMonitor()
+	if got, want := gaugeValues(m, id, omd), []float64{6, 6, 6, 6}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("carried gauge values %v, want %v", got, want)
+	}
+
+	// 增量在延续的最后值的基础上计算, 延续的值不计入最小值 最大值 平均值
+	m.AddPersistent(id, GaugeMetric, 4)
+	omd = m.Core.NextMonitor()
+	if got, want := gaugeValues(m, id, omd), []float64{10, 10, 10, 10}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("gauge values after add %v, want %v", got, want)
+	}
+
+	// 上一个周期最后为 100, 本周期开始设置为 0, 最大值不受延续的值影响
+	m.SetPersistent(id, GaugeMetric, 100)
+	m.Core.NextMonitor()
+	m.SetPersistent(id, GaugeMetric, 0)
+	m.SetPersistent(id, GaugeMetric, 2)
+	omd = m.Core.NextMonitor()
+	if got, want := gaugeValues(m, id, omd), []float64{2, 0, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("gauge values after set %v, want %v", got, want)
+	}
+
+	// 连续多个周期没有取值时继续延续
+	m.Core.NextMonitor()
+	omd = m.Core.NextMonitor()
+	if got, want := gaugeValues(m, id, omd), []float64{2, 2, 2, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("gauge values after idle %v, want %v", got, want)
+	}
+
+	for _, p := range collectPoints(m.Core.MetricMap, omd) {
+		if p.Name == "queue.depth" && (!reflect.DeepEqual(p.Suffix, GaugeSuffix) || p.Tags["queue"] != "jobs") {
+			t.Fatalf("unexpected gauge point %+v", p)
+		}
+	}
+}
+
+func TestGaugeCarryConcurrent(t *testing.T) {
+	m, _ := New(NewConfig())
+	id, _ := m.RegisterMetric("inflight", GaugeMetric, "", nil)
+
+	// 并发的增减与切换, 最终值应为所有增量之和
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.AddPersistent(id, GaugeMetric, 1)
+				m.AddPersistent(id, GaugeMetric, -1)
+			}
+			m.AddPersistent(id, GaugeMetric, 1)
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		m.Core.NextMonitor()
+	}
+	wg.Wait()
+
+	omd := m.Core.NextMonitor()
+	if last := gaugeValues(m, id, omd)[0]; last != 8 {
+		t.Fatalf("gauge last %v, want 8", last)
+	}
+}
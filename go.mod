@@ -1,6 +1,6 @@
 module monitor
 
-go 1.13
+go 1.21
 
 require (
 	github.com/caio/go-tdigest v3.1.0+incompatible
@@ -0,0 +1,144 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+)
+
+// Graphite Carbon pickle 协议的 writer
+// 每批数据为 [(path, (timestamp, value)), ...] 的 pickle, 前面加 4 字节大端的长度
+// path 为 指标名.tag1.值1.tag2.值2.后缀, tags 按 key 排序, 包含 host
+
+// init 注册一个初始化 GraphitePickleWriter 的 Writer
+func init() {
+	f := func(conf *WriterConfig) Writer {
+		return &GraphitePickleWriter{
+			Conf:     conf,
+			Describe: "A Graphite pickle protocol writer",
+		}
+	}
+
+	RegisterWriterName["GraphitePickleWriter"] = f
+}
+
+const (
+	// graphitePickleBatchSize 默认每批发送的数据点个数
+	graphitePickleBatchSize = 500
+	// graphitePickleBatchBytes 默认每批发送的最大字节数
+	graphitePickleBatchBytes = 64 * 1024
+)
+
+// pickle 协议 2 用到的操作码
+const (
+	pickleProto      = 0x80
+	pickleEmptyList  = ']'
+	pickleMark       = '('
+	pickleBinUnicode = 'X'
+	pickleBinInt     = 'J'
+	pickleBinFloat   = 'G'
+	pickleTuple2     = 0x86
+	pickleAppends    = 'e'
+	pickleStop       = '.'
+)
+
+// GraphitePickleWriter Graphite pickle 协议的 writer, 通过 tcp 发送
+type GraphitePickleWriter struct {
+	Conf     *WriterConfig
+	Describe string
+}
+
+// DoWithRecover 处理一分钟的数据
+func (j *GraphitePickleWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			Logger.Error("writer panic at DoWithRecover", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyPanic, p)
+			err = &ErrWriterPanic{Name: j.Conf.Name, Panic: p}
+		}
+	}()
+
+	samples := splitSamples(pointsWithHost(writerPoints(j.Conf, nameMap, omd)))
+	batches := splitBatches(samples, batchSize(j.Conf, graphitePickleBatchSize),
+		batchBytes(j.Conf, graphitePickleBatchBytes)-graphitePickleOverhead, graphitePickleSize)
+
+	msgs := make([][]byte, 0, len(batches))
+	for _, batch := range batches {
+		msgs = append(msgs, encodeGraphitePickle(batch, omd.Ts))
+	}
+
+	if err = uploadTCPBatches(j.Conf, msgs); err != nil {
+		Logger.Error("writer upload error", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+	}
+	return
+}
+
+// graphitePickleOverhead pickle 消息中长度头及固定的开头结尾的字节数
+const graphitePickleOverhead = 4 + 4 + 2
+
+// graphitePickleSize 返回一个数据点在 pickle 消息中的字节数
+func graphitePickleSize(s sample) int {
+	return 1 + 4 + len(graphitePath(s)) + 1 + 4 + 1 + 8 + 2
+}
+
+// encodeGraphitePickle 将一批数据点编码为带长度头的 pickle 消息
+func encodeGraphitePickle(batch []sample, ts time.Time) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0}) // 长度头占位
+	buf.Write([]byte{pickleProto, 2, pickleEmptyList, pickleMark})
+
+	num := make([]byte, 8)
+	for _, s := range batch {
+		path := graphitePath(s)
+		buf.WriteByte(pickleBinUnicode)
+		binary.LittleEndian.PutUint32(num, uint32(len(path)))
+		buf.Write(num[:4])
+		buf.WriteString(path)
+
+		buf.WriteByte(pickleBinInt)
+		binary.LittleEndian.PutUint32(num, uint32(int32(ts.Unix())))
+		buf.Write(num[:4])
+
+		buf.WriteByte(pickleBinFloat)
+		binary.BigEndian.PutUint64(num, math.Float64bits(s.Value()))
+		buf.Write(num)
+
+		buf.Write([]byte{pickleTuple2, pickleTuple2})
+	}
+	buf.Write([]byte{pickleAppends, pickleStop})
+
+	msg := buf.Bytes()
+	binary.BigEndian.PutUint32(msg, uint32(len(msg)-4))
+	return msg
+}
+
+// graphitePath 返回数据点的 Graphite 路径
+// 指标名中的 . 保留为层级, tag 的 key 和值中的 . 替换为 _
+func graphitePath(s sample) string {
+	p := s.point
+
+	var buf strings.Builder
+	buf.WriteString(sanitizeGraphite(p.Name, true))
+	for _, k := range p.SortedTagKeys() {
+		if p.Tags[k] == "" {
+			continue
+		}
+		buf.WriteByte('.')
+		buf.WriteString(sanitizeGraphite(k, false))
+		buf.WriteByte('.')
+		buf.WriteString(sanitizeGraphite(p.Tags[k], false))
+	}
+	if !p.Plain {
+		buf.WriteByte('.')
+		buf.WriteString(p.FieldName(s.index))
+	}
+	return buf.String()
+}
+
+// sanitizeGraphite Graphite 路径只保留字母, 数字及 - _ :, keepDot 为 true 时保留 .
+func sanitizeGraphite(s string, keepDot bool) string {
+	return sanitize(s, func(r rune) bool {
+		return r == '-' || r == '_' || r == ':' || (keepDot && r == '.')
+	})
+}
@@ -0,0 +1,79 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGraphitePickleWriter(t *testing.T) {
+	c := newTCPCollector(t)
+	conf := c.config("GraphitePickleWriter")
+	conf.ValidateBatchSize(2)
+
+	w, _ := InitWriter(conf)
+	if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+		t.Fatalf("graphite pickle writer error %s", err)
+	}
+
+	data := c.received(t)
+	paths := 0
+	for msgs := 0; len(data) > 0; msgs++ {
+		n := int(binary.BigEndian.Uint32(data))
+		msg := data[4 : 4+n]
+		if msg[0] != pickleProto || msg[1] != 2 || msg[len(msg)-1] != pickleStop {
+			t.Fatalf("message %d not a pickle: %q", msgs, msg)
+		}
+		paths += strings.Count(string(msg), string([]byte{pickleTuple2, pickleTuple2}))
+		data = data[4+n:]
+	}
+	if paths != 3 {
+		t.Fatalf("paths = %d, want 3", paths)
+	}
+}
+
+func TestGraphitePickleWriterBatchBytes(t *testing.T) {
+	c := newTCPCollector(t)
+	conf := c.config("GraphitePickleWriter")
+	// 每批只能放下一个数据点
+	conf.ValidateBatchBytes(graphitePickleOverhead + 1)
+
+	w, _ := InitWriter(conf)
+	if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+		t.Fatalf("graphite pickle writer error %s", err)
+	}
+
+	data := c.received(t)
+	msgs := 0
+	for ; len(data) > 0; msgs++ {
+		n := int(binary.BigEndian.Uint32(data))
+		data = data[4+n:]
+	}
+	if msgs != 3 {
+		t.Fatalf("messages = %d, want 3", msgs)
+	}
+}
+
+func TestEncodeGraphitePickle(t *testing.T) {
+	p := &Point{
+		Name:   "api.latency",
+		Tags:   map[string]string{"route": "/users"},
+		Suffix: []string{"_Avg"},
+		Values: []float64{0.5},
+	}
+
+	got := encodeGraphitePickle(splitSamples([]*Point{p}), time.Unix(1564617600, 0))
+	path := "api.latency.route._users.Avg"
+	want := "\x00\x00\x00\x37" + "\x80\x02](" +
+		"X\x1c\x00\x00\x00" + path +
+		"J\x80\x2b\x42\x5d" +
+		"G\x3f\xe0\x00\x00\x00\x00\x00\x00" +
+		"\x86\x86e."
+	if string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if n := graphitePickleOverhead + graphitePickleSize(splitSamples([]*Point{p})[0]); n != len(got) {
+		t.Fatalf("size %d, encoded %d", n, len(got))
+	}
+}
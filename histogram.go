@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 固定分桶的直方图, 每个桶记录 <= 上界的个数, 多台主机的结果可以按桶直接相加
+// 输出为各上界的累计计数, 最后一个桶为 +Inf, 之后为 _Count _Sum
+
+var (
+	// DefaultBuckets 直方图默认的桶上界, 适用于以秒为单位的耗时
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	// HistogramSuffix 默认分桶的直方图后缀
+	HistogramSuffix = histogramSuffixes(DefaultBuckets)
+
+	// histogramStatSuffix 直方图在各桶之后输出的后缀
+	histogramStatSuffix = []string{"_Count", "_Sum"}
+
+	// bucketNameReplacer 桶上界用于后缀时替换的字符
+	bucketNameReplacer = strings.NewReplacer(".", "_", "+", "")
+)
+
+// LinearBuckets 返回 count 个线性的桶上界, 从 start 开始每个增加 width
+func LinearBuckets(start, width float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start + float64(i)*width
+	}
+	return buckets
+}
+
+// ExponentialBuckets 返回 count 个指数增长的桶上界, 从 start 开始每个乘以 factor
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start * math.Pow(factor, float64(i))
+	}
+	return buckets
+}
+
+// WithBuckets 直方图的桶上界, 需严格递增, +Inf 的桶总是存在不需要指定
+// 可使用 LinearBuckets ExponentialBuckets 生成, 未配置时为 DefaultBuckets
+func WithBuckets(buckets ...float64) MetricOption {
+	return func(m *MetricName) error {
+		if m.Type != HistogramMetric {
+			return &ErrMetricOption{Name: m.Name, Msg: "buckets only for HistogramMetric"}
+		}
+		if len(buckets) > 0 && math.IsInf(buckets[len(buckets)-1], 1) {
+			buckets = buckets[:len(buckets)-1]
+		}
+		if len(buckets) == 0 {
+			return &ErrMetricOption{Name: m.Name, Msg: "buckets is empty"}
+		}
+
+		for i, b := range buckets {
+			if math.IsNaN(b) || math.IsInf(b, 0) {
+				return &ErrMetricOption{Name: m.Name, Msg: fmt.Sprintf("bucket %v not finite", b)}
+			}
+			if i > 0 && b <= buckets[i-1] {
+				return &ErrMetricOption{Name: m.Name, Msg: "buckets must be strictly increasing"}
+			}
+		}
+		m.Buckets = append([]float64(nil), buckets...)
+		return nil
+	}
+}
+
+// bucketSuffix 返回桶上界的后缀, 如 0.5 为 _Le0_5, +Inf 为 _LeInf
+func bucketSuffix(bound float64) string {
+	if math.IsInf(bound, 1) {
+		return "_LeInf"
+	}
+	return "_Le" + bucketNameReplacer.Replace(strconv.FormatFloat(bound, 'g', -1, 64))
+}
+
+// histogramSuffixes 返回直方图的后缀, 各桶之后为 _LeInf _Count _Sum
+func histogramSuffixes(buckets []float64) []string {
+	suffix := make([]string, 0, len(buckets)+1+len(histogramStatSuffix))
+	for _, b := range buckets {
+		suffix = append(suffix, bucketSuffix(b))
+	}
+	suffix = append(suffix, bucketSuffix(math.Inf(1)))
+	return append(suffix, histogramStatSuffix...)
+}
+
+// newHistogramSpecValue 返回直方图的特殊数据类型, 桶的个数为上界个数加 +Inf
+func newHistogramSpecValue(buckets []float64) *SpecValue {
+	return &SpecValue{
+		Bounds:  buckets,
+		Buckets: make([]int64, len(buckets)+1),
+	}
+}
+
+// observe 将 value 记录到第一个上界 >= value 的桶中
+func (s *SpecValue) observe(value float64) {
+	s.Buckets[sort.SearchFloat64s(s.Bounds, value)]++
+}
+
+// histogramTotals 返回配置了 WithCumulative 的直方图自开始以来各桶的累计计数, 按桶累计, 最后一个为 +Inf
+func histogramTotals(s *SpecValue) []float64 {
+	totals := make([]float64, 0, len(s.Buckets))
+	var cumulative int64
+	for i, n := range s.Buckets {
+		cumulative += n
+		if s.TotalBuckets != nil {
+			cumulative += s.TotalBuckets[i]
+		}
+		totals = append(totals, float64(cumulative))
+	}
+	return totals
+}
+
+// histogramValues 返回各桶的累计计数及 Count Sum, 与 histogramSuffixes 一一对应
+func histogramValues(s *SpecValue) []float64 {
+	values := make([]float64, 0, len(s.Buckets)+len(histogramStatSuffix))
+	var cumulative int64
+	for _, n := range s.Buckets {
+		cumulative += n
+		values = append(values, float64(cumulative))
+	}
+	return append(values, float64(s.Count), s.Sum)
+}
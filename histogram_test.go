@@ -0,0 +1,157 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBucketsHelpers(t *testing.T) {
+	if got := LinearBuckets(1, 2, 4); !reflect.DeepEqual(got, []float64{1, 3, 5, 7}) {
+		t.Fatalf("linear buckets %v", got)
+	}
+	if got := ExponentialBuckets(1, 10, 4); !reflect.DeepEqual(got, []float64{1, 10, 100, 1000}) {
+		t.Fatalf("exponential buckets %v", got)
+	}
+
+	m, _ := New(NewConfig())
+	for _, opt := range []MetricOption{WithBuckets(), WithBuckets(1, 1), WithBuckets(2, 1), WithBuckets(math.NaN())} {
+		if _, err := m.RegisterMetric("bad", HistogramMetric, "", nil, opt); err == nil {
+			t.Fatalf("expect bucket option error")
+		}
+	}
+	if _, err := m.RegisterMetric("bad", QuantileMetric, "", nil, WithBuckets(1)); err == nil {
+		t.Fatalf("expect error for buckets on QuantileMetric")
+	}
+}
+
+// newHistogramPoint 注册一个桶上界为 1 10 100 的直方图并记录 values
+func newHistogramPoint(t *testing.T, values ...float64) (*MONITOR, *OneMinStorage, *Point) {
+	m, _ := New(NewConfig())
+	id, err := m.RegisterMetric("req.size", HistogramMetric, "request size", map[string]string{"route": "/users"},
+		WithBuckets(1, 10, 100, math.Inf(1)))
+	if err != nil {
+		t.Fatalf("register error %s", err)
+	}
+	for _, v := range values {
+		m.AddPersistent(id, HistogramMetric, v)
+	}
+
+	omd := m.Core.NextMonitor()
+	for _, p := range collectPoints(m.Core.MetricMap, omd) {
+		if p.Name == "req.size" {
+			return m, omd, p
+		}
+	}
+	t.Fatalf("histogram point not found")
+	return nil, nil, nil
+}
+
+func TestHistogramMetric(t *testing.T) {
+	_, _, p := newHistogramPoint(t, 0.5, 1, 5, 50, 500, 5000)
+
+	wantSuffix := []string{"_Le1", "_Le10", "_Le100", "_LeInf", "_Count", "_Sum"}
+	if !reflect.DeepEqual(p.Suffix, wantSuffix) {
+		t.Fatalf("suffix %v, want %v", p.Suffix, wantSuffix)
+	}
+	// 累计计数, 上界包含等于的值
+	if want := []float64{2, 3, 4, 6, 6, 5556.5}; !reflect.DeepEqual(p.Values, want) {
+		t.Fatalf("values %v, want %v", p.Values, want)
+	}
+	if !reflect.DeepEqual(p.Buckets, []float64{1, 10, 100}) {
+		t.Fatalf("buckets %v", p.Buckets)
+	}
+
+	// 默认分桶
+	metric, _ := initMetricName("latency", HistogramMetric, "", nil)
+	if !reflect.DeepEqual(metric.Suffixes(), HistogramSuffix) || HistogramSuffix[0] != "_Le0_005" {
+		t.Fatalf("default suffix %v", metric.Suffixes())
+	}
+}
+
+func TestHistogramPrometheus(t *testing.T) {
+	m, omd, _ := newHistogramPoint(t, 0.5, 1, 5, 50, 500, 5000)
+
+	got := formatString(t, PrometheusFormat, NewSnapshot(m.Core.MetricMap, omd))
+	want := `# HELP req_size request size
+# TYPE req_size histogram
+req_size_bucket{route="/users",le="1"} 2
+req_size_bucket{route="/users",le="10"} 3
+req_size_bucket{route="/users",le="100"} 4
+req_size_bucket{route="/users",le="+Inf"} 6
+req_size_sum{route="/users"} 5556.5
+req_size_count{route="/users"} 6
+`
+	if !strings.Contains(got, want) {
+		t.Fatalf("unexpected prometheus histogram:\n%s", got)
+	}
+}
+
+func TestHistogramCumulativePrometheus(t *testing.T) {
+	m, _ := New(NewConfig())
+	id, _ := m.RegisterMetric("req.size", HistogramMetric, "", nil, WithBuckets(1, 10), WithCumulative())
+
+	format := func() string {
+		return formatString(t, PrometheusFormat, NewSnapshot(m.Core.MetricMap, m.Core.NextMonitor()))
+	}
+	m.AddPersistent(id, HistogramMetric, 0.5)
+	m.AddPersistent(id, HistogramMetric, 5)
+	format()
+
+	// 各桶的计数跨周期累计, 空周期也不减少
+	m.AddPersistent(id, HistogramMetric, 50)
+	format()
+	got := format()
+	for _, line := range []string{
+		"# TYPE req_size histogram\n",
+		`req_size_bucket{le="1"} 1` + "\n",
+		`req_size_bucket{le="10"} 2` + "\n",
+		`req_size_bucket{le="+Inf"} 3` + "\n",
+		"req_size_sum 55.5\n",
+		"req_size_count 3\n",
+	} {
+		if !strings.Contains(got, line) {
+			t.Fatalf("missing %q in\n%s", line, got)
+		}
+	}
+	if strings.Contains(got, "CountTotal") {
+		t.Fatalf("totals already in histogram:\n%s", got)
+	}
+}
+
+func TestHistogramOTLP(t *testing.T) {
+	_, _, p := newHistogramPoint(t, 0.5, 1, 5, 50, 500, 5000)
+
+	body := encodeOTLPMetrics([]*Point{p}, time.Unix(1564617600, 0), time.Unix(1564617660, 0))
+	rm := decodeProto(t, decodeProto(t, body)[1][0].b)
+	metric := decodeProto(t, decodeProto(t, rm[2][0].b)[2][0].b)
+
+	h := decodeProto(t, metric[9][0].b)
+	if h[2][0].v != otlpTemporalityDelta {
+		t.Fatalf("histogram not delta: %v", h)
+	}
+	dp := decodeProto(t, h[1][0].b)
+	if dp[4][0].v != 6 || math.Float64frombits(dp[5][0].v) != 5556.5 {
+		t.Fatalf("unexpected count sum %v", dp)
+	}
+
+	// bucket_counts 为各桶自身的计数
+	counts := dp[6][0].b
+	var got []uint64
+	for len(counts) > 0 {
+		got = append(got, binary.LittleEndian.Uint64(counts))
+		counts = counts[8:]
+	}
+	if !reflect.DeepEqual(got, []uint64{2, 1, 1, 2}) {
+		t.Fatalf("bucket counts %v", got)
+	}
+	if len(dp[7][0].b) != 24 || math.Float64frombits(binary.LittleEndian.Uint64(dp[7][0].b[16:])) != 100 {
+		t.Fatalf("unexpected explicit bounds %v", dp[7][0].b)
+	}
+	if string(decodeProto(t, dp[9][0].b)[1][0].b) != "route" {
+		t.Fatalf("histogram attribute missing")
+	}
+}
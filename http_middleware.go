@@ -0,0 +1,323 @@
+package monitor
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// HTTP 服务端中间件及客户端 RoundTripper
+// 服务端记录 请求耗时及次数 (按 method route status 分类) 进行中的请求数 及响应大小
+// route 为 gorilla/mux 的路由模版, 如 /users/{id}, 不使用原始路径, 避免序列数不可控
+// 客户端按 method host status 记录耗时及次数, 请求出错时 status 为 error
+
+// HTTP 指标名
+const (
+	HTTPServerRequests     = "http.server.requests"      // 服务端请求耗时, TimerMetric
+	HTTPServerInFlight     = "http.server.in_flight"     // 服务端进行中的请求数, GaugeMetric
+	HTTPServerResponseSize = "http.server.response_size" // 服务端响应大小, 单位字节, HistogramMetric
+	HTTPClientRequests     = "http.client.requests"      // 客户端请求耗时, TimerMetric
+)
+
+const (
+	// httpUnknownRoute 无法获取路由模版时的 route
+	httpUnknownRoute = "unknown"
+	// httpOtherMethod 非标准的 method, 避免序列数不可控
+	httpOtherMethod = "OTHER"
+	// httpErrorStatus 客户端请求出错, 没有响应时的 status
+	httpErrorStatus = "error"
+	// httpOtherStatus 不合法的状态码
+	httpOtherStatus = "other"
+)
+
+// HTTPResponseSizeBuckets 响应大小的桶上界, 100B 到 100MB
+var HTTPResponseSizeBuckets = ExponentialBuckets(100, 10, 7)
+
+// httpMethods 标准的 method
+var httpMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodConnect: true,
+	http.MethodOptions: true, http.MethodTrace: true,
+}
+
+// httpSeries HTTP 指标序列的缓存 key
+type httpSeries struct {
+	name, method, route, host, status string
+}
+
+// httpSeriesCache HTTP 指标序列的 ID 缓存, 避免每次请求拼接 key 及查找
+type httpSeriesCache struct {
+	sync.RWMutex
+	ids map[httpSeries]int
+}
+
+// httpSeriesID 返回 HTTP 指标序列的 ID, 未注册时注册, route host status 为空时不添加对应的 tag
+// 注册失败时返回 false
+func (m *MONITOR) httpSeriesID(s httpSeries, metricType int, opts ...MetricOption) (int, bool) {
+	m.httpSeries.RLock()
+	id, ok := m.httpSeries.ids[s]
+	m.httpSeries.RUnlock()
+	if ok {
+		return id, true
+	}
+
+	tags := map[string]string{"method": s.method}
+	if s.route != "" {
+		tags["route"] = s.route
+	}
+	if s.host != "" {
+		tags["host"] = s.host
+	}
+	if s.status != "" {
+		tags["status"] = s.status
+	}
+
+	id, err := m.RegisterMetric(s.name, metricType, "http "+s.name, tags, opts...)
+	if err != nil {
+		Logger.Error("register http metric error", LogKeyMetric, s.name, LogKeyErr, err)
+		return -1, false
+	}
+
+	m.httpSeries.Lock()
+	if m.httpSeries.ids == nil {
+		m.httpSeries.ids = make(map[httpSeries]int)
+	}
+	m.httpSeries.ids[s] = id
+	m.httpSeries.Unlock()
+	return id, true
+}
+
+// httpMethod 返回 method 的 tag 值, 非标准的为 OTHER
+func httpMethod(method string) string {
+	if httpMethods[method] {
+		return method
+	}
+	return httpOtherMethod
+}
+
+// httpStatusClass 返回状态码的类别, 如 2xx
+func httpStatusClass(code int) string {
+	if code < 100 || code > 599 {
+		return httpOtherStatus
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// httpRoute 返回请求匹配的 gorilla/mux 路由模版, 没有时返回 unknown
+func httpRoute(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return httpUnknownRoute
+}
+
+// Middleware 返回记录请求指标的 http.Handler, 可直接用于 mux.Router 的 Use
+// route 在 handler 执行前从 gorilla/mux 获取, 因此需要在路由匹配之后使用, 否则为 unknown
+func (m *MONITOR) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.serveHTTP(next, w, r, httpRoute(r))
+	})
+}
+
+// InstrumentHandler 返回记录请求指标的 http.Handler, route 为指定的值, 用于未使用 gorilla/mux 的 handler
+func (m *MONITOR) InstrumentHandler(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.serveHTTP(next, w, r, route)
+	})
+}
+
+// serveHTTP 执行 next 并记录指标, handler panic 时按 500 记录后继续 panic
+func (m *MONITOR) serveHTTP(next http.Handler, w http.ResponseWriter, r *http.Request, route string) {
+	start := time.Now()
+	method := httpMethod(r.Method)
+
+	inFlight, ok := m.httpSeriesID(httpSeries{name: HTTPServerInFlight, method: method, route: route}, GaugeMetric)
+	if ok {
+		m.AddPersistent(inFlight, GaugeMetric, 1)
+	}
+
+	rw := &responseWriter{ResponseWriter: w}
+	defer func() {
+		if ok {
+			m.AddPersistent(inFlight, GaugeMetric, -1)
+		}
+
+		p := recover()
+		status := rw.status
+		if p != nil {
+			status = http.StatusInternalServerError
+		} else if status == 0 {
+			status = http.StatusOK
+		}
+
+		if id, ok := m.httpSeriesID(httpSeries{name: HTTPServerRequests, method: method, route: route,
+			status: httpStatusClass(status)}, TimerMetric); ok {
+			m.AddPersistent(id, TimerMetric, float64(time.Since(start))/float64(DefaultTimeUnit))
+		}
+		if id, ok := m.httpSeriesID(httpSeries{name: HTTPServerResponseSize, method: method, route: route},
+			HistogramMetric, WithBuckets(HTTPResponseSizeBuckets...)); ok {
+			m.AddPersistent(id, HistogramMetric, float64(rw.written))
+		}
+
+		if p != nil {
+			panic(p)
+		}
+	}()
+
+	next.ServeHTTP(rw.wrap(), r)
+}
+
+// responseWriter 记录状态码及写入的字节数
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+// WriteHeader 记录第一次写入的状态码
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write 记录写入的字节数, 未写状态码时为 200
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// wrap 返回与底层的 ResponseWriter 实现相同可选接口的 ResponseWriter
+// 只有底层支持时才实现 http.Flusher http.Hijacker io.ReaderFrom, 类型断言的结果与底层一致
+func (w *responseWriter) wrap() http.ResponseWriter {
+	_, isFlusher := w.ResponseWriter.(http.Flusher)
+	_, isHijacker := w.ResponseWriter.(http.Hijacker)
+	_, isReaderFrom := w.ResponseWriter.(io.ReaderFrom)
+	f, h, rf := flusher{w}, hijacker{w}, readerFrom{w}
+
+	switch {
+	case isFlusher && isHijacker && isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{w, f, h, rf}
+	case isFlusher && isHijacker:
+		return struct {
+			*responseWriter
+			http.Flusher
+			http.Hijacker
+		}{w, f, h}
+	case isFlusher && isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{w, f, rf}
+	case isHijacker && isReaderFrom:
+		return struct {
+			*responseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{w, h, rf}
+	case isFlusher:
+		return struct {
+			*responseWriter
+			http.Flusher
+		}{w, f}
+	case isHijacker:
+		return struct {
+			*responseWriter
+			http.Hijacker
+		}{w, h}
+	case isReaderFrom:
+		return struct {
+			*responseWriter
+			io.ReaderFrom
+		}{w, rf}
+	}
+	return w
+}
+
+// flusher 底层支持时实现 http.Flusher, 未写状态码时为 200
+type flusher struct{ w *responseWriter }
+
+func (f flusher) Flush() {
+	if f.w.status == 0 {
+		f.w.status = http.StatusOK
+	}
+	f.w.ResponseWriter.(http.Flusher).Flush()
+}
+
+// hijacker 底层支持时实现 http.Hijacker
+type hijacker struct{ w *responseWriter }
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// readerFrom 底层支持时实现 io.ReaderFrom, 保留 sendfile 等优化, 记录写入的字节数
+type readerFrom struct{ w *responseWriter }
+
+func (r readerFrom) ReadFrom(src io.Reader) (int64, error) {
+	if r.w.status == 0 {
+		r.w.status = http.StatusOK
+	}
+	n, err := r.w.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	r.w.written += n
+	return n, err
+}
+
+// Unwrap 返回底层的 ResponseWriter, 用于 http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// RoundTripper 返回记录客户端请求指标的 http.RoundTripper, next 为 nil 时使用 http.DefaultTransport
+// 按 method host status 记录耗时, 耗时为到收到响应头为止
+func (m *MONITOR) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &roundTripper{m: m, next: next}
+}
+
+// roundTripper 记录指标的 http.RoundTripper
+type roundTripper struct {
+	m    *MONITOR
+	next http.RoundTripper
+}
+
+// RoundTrip 实现 http.RoundTripper
+func (t *roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(r)
+
+	status := httpErrorStatus
+	if err == nil {
+		status = httpStatusClass(resp.StatusCode)
+	}
+	host := r.URL.Host
+	if host == "" {
+		host = r.Host
+	}
+	if id, ok := t.m.httpSeriesID(httpSeries{name: HTTPClientRequests, method: httpMethod(r.Method),
+		host: host, status: status}, TimerMetric); ok {
+		t.m.AddPersistent(id, TimerMetric, float64(time.Since(start))/float64(DefaultTimeUnit))
+	}
+	return resp, err
+}
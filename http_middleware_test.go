@@ -0,0 +1,156 @@
+package monitor
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMiddleware(t *testing.T) {
+	m, _ := New(NewConfig())
+
+	r := mux.NewRouter()
+	r.Use(m.Middleware)
+	r.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 150)))
+	})
+	r.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "fail", http.StatusServiceUnavailable)
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/fail"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/users/3", nil))
+
+	points := collectPoints(m.Core.MetricMap, m.Core.NextMonitor())
+	users := map[string]string{"method": "GET", "route": "/users/{id}", "status": "2xx"}
+	if got := pointValue(t, points, HTTPServerRequests, users, "Count"); got != 2 {
+		t.Fatalf("users requests %v, want 2", got)
+	}
+	fail := map[string]string{"method": "GET", "route": "/fail", "status": "5xx"}
+	if got := pointValue(t, points, HTTPServerRequests, fail, "Count"); got != 1 {
+		t.Fatalf("fail requests %v, want 1", got)
+	}
+	other := map[string]string{"method": "OTHER", "route": "/users/{id}", "status": "2xx"}
+	if got := pointValue(t, points, HTTPServerRequests, other, "Count"); got != 1 {
+		t.Fatalf("other method requests %v, want 1", got)
+	}
+
+	route := map[string]string{"method": "GET", "route": "/users/{id}"}
+	if got := pointValue(t, points, HTTPServerResponseSize, route, "Le1000"); got != 2 {
+		t.Fatalf("response size <= 1000 %v, want 2", got)
+	}
+	if got := pointValue(t, points, HTTPServerResponseSize, route, "Le100"); got != 0 {
+		t.Fatalf("response size <= 100 %v, want 0", got)
+	}
+	// 请求结束后进行中的请求数回到 0, 最大值为 1
+	if got := pointValue(t, points, HTTPServerInFlight, route, "Last"); got != 0 {
+		t.Fatalf("in flight %v, want 0", got)
+	}
+	if got := pointValue(t, points, HTTPServerInFlight, route, "Max"); got != 1 {
+		t.Fatalf("in flight max %v, want 1", got)
+	}
+}
+
+func TestResponseWriterInterfaces(t *testing.T) {
+	m, _ := New(NewConfig())
+	var flush, hijack, readFrom bool
+	h := m.InstrumentHandler("/file", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flush = w.(http.Flusher)
+		_, hijack = w.(http.Hijacker)
+		rf, ok := w.(io.ReaderFrom)
+		if readFrom = ok; ok {
+			rf.ReadFrom(strings.NewReader(strings.Repeat("x", 2000)))
+		}
+	}))
+
+	// ResponseRecorder 只实现了 http.Flusher
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/file", nil))
+	if !flush || hijack || readFrom {
+		t.Fatalf("recorder interfaces flush %v hijack %v readFrom %v, want only flush", flush, hijack, readFrom)
+	}
+
+	// HTTP/1 的 ResponseWriter 同时实现三者, ReadFrom 写入的字节数同样记录
+	server := httptest.NewServer(h)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("get error %s", err)
+	}
+	resp.Body.Close()
+	if !flush || !hijack || !readFrom {
+		t.Fatalf("server interfaces flush %v hijack %v readFrom %v, want all", flush, hijack, readFrom)
+	}
+
+	points := collectPoints(m.Core.MetricMap, m.Core.NextMonitor())
+	route := map[string]string{"method": "GET", "route": "/file"}
+	if got := pointValue(t, points, HTTPServerResponseSize, route, "Count"); got != 2 {
+		t.Fatalf("response size count %v, want 2", got)
+	}
+	if got := pointValue(t, points, HTTPServerResponseSize, route, "Sum"); got != 2000 {
+		t.Fatalf("response size sum %v, want 2000", got)
+	}
+}
+
+func TestInstrumentHandlerPanic(t *testing.T) {
+	m, _ := New(NewConfig())
+	h := m.InstrumentHandler("/panic", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expect panic to propagate")
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/panic", nil))
+	}()
+
+	points := collectPoints(m.Core.MetricMap, m.Core.NextMonitor())
+	tags := map[string]string{"method": "POST", "route": "/panic", "status": "5xx"}
+	if got := pointValue(t, points, HTTPServerRequests, tags, "Count"); got != 1 {
+		t.Fatalf("panic requests %v, want 1", got)
+	}
+}
+
+type errTransport struct{}
+
+func (errTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("dial error")
+}
+
+func TestRoundTripper(t *testing.T) {
+	m, _ := New(NewConfig())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: m.RoundTripper(nil)}
+	resp, err := client.Get(server.URL + "/missing")
+	if err != nil {
+		t.Fatalf("get error %s", err)
+	}
+	resp.Body.Close()
+
+	failing := &http.Client{Transport: m.RoundTripper(errTransport{})}
+	if _, err := failing.Get("http://example.invalid/"); err == nil {
+		t.Fatal("expect transport error")
+	}
+
+	points := collectPoints(m.Core.MetricMap, m.Core.NextMonitor())
+	host := strings.TrimPrefix(server.URL, "http://")
+	if got := pointValue(t, points, HTTPClientRequests, map[string]string{"method": "GET", "host": host, "status": "4xx"}, "Count"); got != 1 {
+		t.Fatalf("client 4xx %v, want 1", got)
+	}
+	if got := pointValue(t, points, HTTPClientRequests, map[string]string{"method": "GET", "host": "example.invalid", "status": "error"}, "Count"); got != 1 {
+		t.Fatalf("client error %v, want 1", got)
+	}
+}
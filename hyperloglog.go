@@ -0,0 +1,175 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog 基数估计, 内存固定为 2^precision 字节, 相对标准误差约为 1.04/sqrt(2^precision)
+// key 使用 FNV-1a 哈希, 再经过 murmur3 的 fmix64 打散, 相同 precision 的 sketch 可以直接合并
+// 因此不同周期及不同主机的结果可以合并后得到整体的去重计数
+
+const (
+	// DefaultHLLPrecision 默认精度, 16KB 内存, 误差约 0.81%
+	DefaultHLLPrecision = 14
+	// MinHLLPrecision 最小精度
+	MinHLLPrecision = 4
+	// MaxHLLPrecision 最大精度
+	MaxHLLPrecision = 18
+
+	// hllVersion 序列化格式的版本
+	hllVersion = 1
+
+	// FNV-1a 64 位的参数
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// HyperLogLog 基数估计的 sketch, 非并发安全, 由 SpecValue 的锁保护
+type HyperLogLog struct {
+	p         uint8
+	registers []uint8
+}
+
+// NewHyperLogLog 返回精度为 precision 的 HyperLogLog, precision 需在 [MinHLLPrecision, MaxHLLPrecision]
+func NewHyperLogLog(precision int) (*HyperLogLog, error) {
+	if precision < MinHLLPrecision || precision > MaxHLLPrecision {
+		return nil, &ErrHyperLogLog{Msg: fmt.Sprintf("precision %d not in [%d, %d]", precision, MinHLLPrecision, MaxHLLPrecision)}
+	}
+	return &HyperLogLog{
+		p:         uint8(precision),
+		registers: make([]uint8, 1<<uint(precision)),
+	}, nil
+}
+
+// Precision 返回精度
+func (h *HyperLogLog) Precision() int {
+	return int(h.p)
+}
+
+// Add 记录一个 key
+func (h *HyperLogLog) Add(key []byte) {
+	hash := uint64(fnvOffset64)
+	for _, c := range key {
+		hash ^= uint64(c)
+		hash *= fnvPrime64
+	}
+	h.addHash(fmix64(hash))
+}
+
+// AddString 记录一个字符串 key, 与 Add([]byte(key)) 相同
+func (h *HyperLogLog) AddString(key string) {
+	hash := uint64(fnvOffset64)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= fnvPrime64
+	}
+	h.addHash(fmix64(hash))
+}
+
+// addHash 高 p 位为寄存器的下标, 其余位的前导零个数加 1 为寄存器的候选值
+func (h *HyperLogLog) addHash(x uint64) {
+	idx := x >> (64 - h.p)
+	// 最低的 p 位补一个 1, 前导零个数最大为 64-p
+	rho := uint8(bits.LeadingZeros64(x<<h.p|1<<(h.p-1))) + 1
+	if rho > h.registers[idx] {
+		h.registers[idx] = rho
+	}
+}
+
+// fmix64 murmur3 的最终混合函数, 弥补 FNV 高位分布不均匀
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+// Estimate 返回基数的估计值, 较小时使用线性计数修正
+func (h *HyperLogLog) Estimate() float64 {
+	m := float64(len(h.registers))
+
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := hllAlpha(len(h.registers)) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return estimate
+}
+
+// StdError 返回估计值的相对标准误差
+func (h *HyperLogLog) StdError() float64 {
+	return 1.04 / math.Sqrt(float64(len(h.registers)))
+}
+
+// hllAlpha 返回 m 个寄存器时的修正系数
+func hllAlpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/float64(m))
+}
+
+// Merge 将 other 合并到 h 中, 结果为两者 key 的并集的估计, 精度需相同
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if h.p != other.p {
+		return &ErrHyperLogLog{Msg: fmt.Sprintf("merge precision %d into %d", other.p, h.p)}
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+// Clone 返回 h 的拷贝
+func (h *HyperLogLog) Clone() *HyperLogLog {
+	return &HyperLogLog{
+		p:         h.p,
+		registers: append([]uint8(nil), h.registers...),
+	}
+}
+
+// MarshalBinary 序列化, 格式为 版本 精度 各寄存器, 用于跨主机传输后合并
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, 2+len(h.registers))
+	data = append(data, hllVersion, h.p)
+	return append(data, h.registers...), nil
+}
+
+// UnmarshalBinary 反序列化 MarshalBinary 的结果
+func (h *HyperLogLog) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 || data[0] != hllVersion {
+		return &ErrHyperLogLog{Msg: "unknown data version"}
+	}
+	p := int(data[1])
+	if p < MinHLLPrecision || p > MaxHLLPrecision || len(data)-2 != 1<<uint(p) {
+		return &ErrHyperLogLog{Msg: fmt.Sprintf("invalid data for precision %d", p)}
+	}
+	for _, r := range data[2:] {
+		if int(r) > 65-p {
+			return &ErrHyperLogLog{Msg: fmt.Sprintf("register %d out of range", r)}
+		}
+	}
+
+	h.p = uint8(p)
+	h.registers = append(h.registers[:0], data[2:]...)
+	return nil
+}
@@ -0,0 +1,97 @@
+package monitor
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 10000, 200000} {
+		h, _ := NewHyperLogLog(DefaultHLLPrecision)
+		for i := 0; i < n; i++ {
+			key := "user-" + strconv.Itoa(i)
+			h.AddString(key)
+			h.Add([]byte(key)) // 重复的 key 不影响结果
+		}
+
+		got := h.Estimate()
+		if math.Abs(got-float64(n)) > 4*h.StdError()*float64(n)+0.5 {
+			t.Fatalf("estimate %d keys got %v", n, got)
+		}
+	}
+
+	if _, err := NewHyperLogLog(MaxHLLPrecision + 1); err == nil {
+		t.Fatal("expect precision error")
+	}
+}
+
+func TestHyperLogLogMergeMarshal(t *testing.T) {
+	a, _ := NewHyperLogLog(12)
+	b, _ := NewHyperLogLog(12)
+	for i := 0; i < 5000; i++ {
+		a.AddString(strconv.Itoa(i))
+		b.AddString(strconv.Itoa(i + 2500))
+	}
+
+	data, _ := b.MarshalBinary()
+	var c HyperLogLog
+	if err := c.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unmarshal error %s", err)
+	}
+	if c.Estimate() != b.Estimate() {
+		t.Fatalf("unmarshal estimate %v, want %v", c.Estimate(), b.Estimate())
+	}
+	if err := c.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Fatal("expect unmarshal error for truncated data")
+	}
+
+	if err := a.Merge(&c); err != nil {
+		t.Fatalf("merge error %s", err)
+	}
+	if got := a.Estimate(); math.Abs(got-7500) > 4*a.StdError()*7500 {
+		t.Fatalf("merged estimate %v, want about 7500", got)
+	}
+
+	d, _ := NewHyperLogLog(10)
+	if err := a.Merge(d); err == nil {
+		t.Fatal("expect merge precision error")
+	}
+}
+
+func TestCardinalityMetric(t *testing.T) {
+	m, _ := New(NewConfig())
+	id, err := m.RegisterMetric("uv", CardinalityMetric, "unique users", nil, WithHLLPrecision(10))
+	if err != nil {
+		t.Fatalf("register error %s", err)
+	}
+	if _, err := m.RegisterMetric("uv.total", CardinalityMetric, "", nil, WithCumulative()); err == nil {
+		t.Fatal("expect cumulative option error for CardinalityMetric")
+	}
+
+	m.AddCardinality(id, "a")
+	m.AddCardinality(id, "b")
+	m.AddCardinality(id, "a")
+	m.AddPersistent(id, CardinalityMetric, 42)
+	m.AddCardinalityBytes(id, []byte("42")) // 与数值 42 相同
+	first := m.Core.NextMonitor()
+
+	values := getValues(m.Core.MetricMap.Map[id], first.PersistentData[id])
+	if math.Round(values[0]) != 3 || values[1] <= 0 {
+		t.Fatalf("cardinality values %v, want 3 distinct", values)
+	}
+
+	m.AddCardinality(id, "b")
+	m.AddCardinality(id, "c")
+	m.Core.NextMonitor()
+
+	if got := m.Core.Cardinality(id, 2).Estimate(); math.Round(got) != 4 {
+		t.Fatalf("merged two intervals %v, want 4", got)
+	}
+	if got := m.Core.Cardinality(id, 1).Estimate(); math.Round(got) != 2 {
+		t.Fatalf("last interval %v, want 2", got)
+	}
+	if m.Core.Cardinality(-1, 2) != nil {
+		t.Fatal("expect nil for unknown metric")
+	}
+}
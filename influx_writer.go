@@ -0,0 +1,205 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxDB line protocol 的 writer
+// measurement 为指标名, tags 为指标的 Tags 及 host, fields 为各后缀对应的值
+
+// init 注册一个初始化 InfluxWriter 的 Writer
+func init() {
+	f := func(conf *WriterConfig) Writer {
+		return &InfluxWriter{
+			Conf:     conf,
+			Describe: "A InfluxDB line protocol writer",
+			client:   &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	RegisterWriterName["InfluxWriter"] = f
+	RegisterFormatterName[InfluxFormat] = func(conf *WriterConfig) Formatter {
+		return &InfluxFormatter{}
+	}
+}
+
+const (
+	// InfluxDefaultPath InfluxDB http 写入的默认路径
+	InfluxDefaultPath = "/write?db=monitor"
+	// influxUDPPayload udp 单个包的最大长度, 按行切分
+	influxUDPPayload = 1400
+)
+
+// InfluxWriter InfluxDB line protocol 的 writer
+// UP 模式通过 http /write 或 udp 上传, DOWN 模式落地为本地文件
+type InfluxWriter struct {
+	Conf     *WriterConfig
+	Describe string
+
+	client *http.Client
+}
+
+// DoWithRecover 处理一分钟的数据
+func (j *InfluxWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			Logger.Error("writer panic at DoWithRecover", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyPanic, p)
+			err = &ErrWriterPanic{Name: j.Conf.Name, Panic: p}
+		}
+	}()
+
+	var buf bytes.Buffer
+	writeInfluxLines(&buf, writerPoints(j.Conf, nameMap, omd), omd.Ts)
+
+	if j.Conf.Mode == UP || j.Conf.Mode == ALL {
+		err = uploadWithRetry(j.Conf.UploadRetry, func() error {
+			return j.upload(buf.Bytes())
+		})
+		if err != nil {
+			Logger.Error("writer upload error", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+			return err
+		}
+	}
+
+	if j.Conf.Mode == DOWN || j.Conf.Mode == ALL {
+		err = writeFileAtomic(j.Conf.DownPath, buf.Bytes(), fileMode(j.Conf))
+		if err != nil {
+			Logger.Error("writer write file error", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// upload 按配置的协议上传, 默认为 http
+func (j *InfluxWriter) upload(data []byte) error {
+	addr := net.JoinHostPort(j.Conf.UpLoadHost, strconv.Itoa(j.Conf.UpLoadPort))
+
+	switch j.Conf.Protocol {
+	case UDPProtocol:
+		return sendUDPLines(addr, data, influxUDPPayload)
+	case HTTPProtocol, "":
+		path := j.Conf.UploadPath
+		if path == "" {
+			path = InfluxDefaultPath
+		}
+
+		resp, err := j.client.Post("http://"+addr+path, "text/plain; charset=utf-8", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("influx write status %s", resp.Status)
+		}
+		return nil
+	}
+
+	return &ErrorWriterConfig{Msg: ProtocolError}
+}
+
+// InfluxFormatter InfluxDB line protocol 格式
+type InfluxFormatter struct{}
+
+// ContentType 实现 Formatter 接口
+func (f *InfluxFormatter) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+// Format 实现 Formatter 接口
+func (f *InfluxFormatter) Format(w io.Writer, snap *Snapshot) error {
+	var buf bytes.Buffer
+	writeInfluxLines(&buf, snap.Points, snap.Ts)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// sendUDPLines 按行切分为不超过 size 的 udp 包发送
+func sendUDPLines(addr string, data []byte, size int) error {
+	conn, err := net.Dial(UDPProtocol, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for len(data) > 0 {
+		n := len(data)
+		if n > size {
+			// 在 size 之前的最后一个换行处切分, 单行超长时整行发送
+			if i := bytes.LastIndexByte(data[:size], NewLine); i > 0 {
+				n = i + 1
+			} else if i := bytes.IndexByte(data, NewLine); i > 0 {
+				n = i + 1
+			}
+		}
+
+		if _, err = conn.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+
+	return nil
+}
+
+// influx 中需要转义的字符
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// writeInfluxLines 将 Point 列表格式化为 line protocol
+// 时间戳精度为纳秒, NaN 和 Inf 的值 influx 不支持, 直接忽略
+func writeInfluxLines(buf *bytes.Buffer, points []*Point, ts time.Time) {
+	tsStr := strconv.FormatInt(ts.UnixNano(), 10)
+
+	for _, p := range pointsWithHost(points) {
+		var fields bytes.Buffer
+		for i, value := range p.Values {
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			if fields.Len() > 0 {
+				fields.WriteByte(',')
+			}
+			fields.WriteString(influxTagEscaper.Replace(p.FieldName(i)))
+			fields.WriteByte(Equal)
+			fields.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+		}
+		if fields.Len() == 0 {
+			continue
+		}
+
+		buf.WriteString(influxMeasurementEscaper.Replace(p.Name))
+		for _, k := range p.SortedTagKeys() {
+			writeInfluxTag(buf, k, p.Tags[k])
+		}
+
+		buf.WriteByte(' ')
+		buf.Write(fields.Bytes())
+		buf.WriteByte(' ')
+		buf.WriteString(tsStr)
+		buf.WriteByte(NewLine)
+	}
+}
+
+// writeInfluxTag 写入一个 tag, 空值的 tag influx 不支持, 直接忽略
+func writeInfluxTag(buf *bytes.Buffer, k, v string) {
+	if k == "" || v == "" {
+		return
+	}
+	buf.WriteByte(',')
+	buf.WriteString(influxTagEscaper.Replace(k))
+	buf.WriteByte(Equal)
+	buf.WriteString(influxTagEscaper.Replace(v))
+}
@@ -0,0 +1,166 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestStorage 返回一个包含特殊指标和普通指标的周期数据
+func newTestStorage(t *testing.T) (*MetricNameMap, *OneMinStorage) {
+	m, _ := New(NewConfig())
+	id, err := m.RegisterMetric("api latency", CountAvgMetric, "api latency", map[string]string{"route": "/users"})
+	if err != nil {
+		t.Fatalf("register metric error %s", err)
+	}
+	m.AddPersistent(id, CountAvgMetric, 10)
+	m.AddPersistent(id, CountAvgMetric, 30)
+	m.Add("biz.count", 3)
+
+	omd := m.Core.NextMonitor()
+	omd.Ts = time.Unix(1564617600, 0)
+	// 去掉自身监控指标, 便于比较
+	for k := range omd.Data {
+		if strings.HasPrefix(k, SelfMonitorKey) {
+			delete(omd.Data, k)
+		}
+	}
+	return m.Core.MetricMap, omd
+}
+
+func TestInfluxWriterHTTP(t *testing.T) {
+	var body, query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, query = string(b), r.URL.RawQuery
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	conf := NewWriterConfig()
+	conf.ValidWriterName("InfluxWriter")
+	conf.ValidateMode(UpStr)
+	conf.UpLoadHost = u.Hostname()
+	conf.ValidateUpLoadPort(port)
+
+	w, _ := InitWriter(conf)
+	if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+		t.Fatalf("influx writer error %s", err)
+	}
+
+	if query != "db=monitor" {
+		t.Errorf("query = %q", query)
+	}
+	for _, line := range []string{
+		`api\ latency,host=` + HostName + `,route=/users Count=2,Avg=20 1564617600000000000`,
+		`biz.count,host=` + HostName + ` value=3 1564617600000000000`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("body %q missing line %q", body, line)
+		}
+	}
+}
+
+func TestInfluxWriterHTTPError(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	conf := NewWriterConfig()
+	conf.ValidWriterName("InfluxWriter")
+	conf.ValidateMode(UP)
+	conf.UpLoadHost = u.Hostname()
+	conf.ValidateUpLoadPort(port)
+	conf.ValidateUploadRetry(2, false)
+
+	w, _ := InitWriter(conf)
+	if err := w.DoWithRecover(newTestStorage(t)); err == nil {
+		t.Fatal("expect error")
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d, want 3", calls)
+	}
+}
+
+func TestInfluxWriterUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp error %s", err)
+	}
+	defer pc.Close()
+
+	conf := NewWriterConfig()
+	conf.ValidWriterName("InfluxWriter")
+	conf.ValidateMode(UP)
+	conf.ValidateProtocol("UDP")
+	conf.UpLoadHost = "127.0.0.1"
+	conf.ValidateUpLoadPort(pc.LocalAddr().(*net.UDPAddr).Port)
+
+	w, _ := InitWriter(conf)
+	if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+		t.Fatalf("influx writer error %s", err)
+	}
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read udp error %s", err)
+	}
+	if got := string(buf[:n]); strings.Count(got, "\n") != 2 {
+		t.Fatalf("unexpected packet %q", got)
+	}
+}
+
+func TestInfluxWriterDown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "influx.txt")
+
+	conf := NewWriterConfig()
+	conf.ValidWriterName("InfluxWriter")
+	conf.DownPath = path
+
+	w, _ := InitWriter(conf)
+	if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+		t.Fatalf("influx writer error %s", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil || !strings.Contains(string(b), "biz.count,host=") {
+		t.Fatalf("unexpected file %q, %v", b, err)
+	}
+}
+
+func TestSendUDPLinesSplit(t *testing.T) {
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	defer pc.Close()
+
+	line := strings.Repeat("a", 9) + "\n"
+	if err := sendUDPLines(pc.LocalAddr().String(), []byte(strings.Repeat(line, 5)), 25); err != nil {
+		t.Fatalf("send error %s", err)
+	}
+
+	buf := make([]byte, 64)
+	for _, want := range []int{20, 20, 10} {
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil || n != want {
+			t.Fatalf("packet size = %d, want %d, err %v", n, want, err)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+)
+
+// 追加写入 JSON Lines 文件的 writer, 每个指标一行, 保留每个周期的数据
+// 文件按 WriterConfig 的 MaxFileSize RotateInterval MaxBackups 轮转
+
+// init 注册一个初始化 JSONLinesWriter 的 Writer
+func init() {
+	f := func(conf *WriterConfig) Writer {
+		return &JSONLinesWriter{
+			Conf:     conf,
+			Describe: "A append only JSON Lines file writer",
+			file:     newRotateFile(conf),
+		}
+	}
+
+	RegisterWriterName["JSONLinesWriter"] = f
+}
+
+// JSONLinesWriter JSON Lines 文件的 writer
+type JSONLinesWriter struct {
+	Conf     *WriterConfig
+	Describe string
+
+	file *rotateFile
+}
+
+// jsonLine 一个指标在一个周期内的 json 行
+type jsonLine struct {
+	Ts     int64              `json:"ts"`
+	Start  int64              `json:"start,omitempty"`
+	Host   string             `json:"host"`
+	Name   string             `json:"name"`
+	Tags   map[string]string  `json:"tags,omitempty"`
+	Type   int                `json:"type"`
+	Unit   string             `json:"unit,omitempty"`
+	Values map[string]float64 `json:"values"`
+}
+
+// DoWithRecover 处理一分钟的数据
+func (j *JSONLinesWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			Logger.Error("writer panic at DoWithRecover", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyPanic, p)
+			err = &ErrWriterPanic{Name: j.Conf.Name, Panic: p}
+		}
+	}()
+
+	var buf bytes.Buffer
+	if err = writeJSONLines(&buf, writerPoints(j.Conf, nameMap, omd), omd.Ts.Unix()); err != nil {
+		Logger.Error("writer format error", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+		return err
+	}
+
+	if err = j.file.Write(buf.Bytes(), nil, omd.Ts); err != nil {
+		Logger.Error("writer write file error", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+	}
+	return
+}
+
+// Close 关闭正在写入的文件, Monitor Stop 时调用
+func (j *JSONLinesWriter) Close() error {
+	return j.file.Close()
+}
+
+// writeJSONLines 将 Point 列表格式化为 JSON Lines, NaN 和 Inf 的值 json 不支持, 直接忽略
+func writeJSONLines(buf *bytes.Buffer, points []*Point, ts int64) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+
+	for _, p := range points {
+		line := &jsonLine{
+			Ts:     ts,
+			Host:   HostName,
+			Name:   p.Name,
+			Tags:   p.Tags,
+			Type:   p.Type,
+			Unit:   p.Unit,
+			Values: make(map[string]float64, len(p.Values)),
+		}
+		if !p.Start.IsZero() {
+			line.Start = p.Start.Unix()
+		}
+		for i, value := range p.Values {
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			line.Values[p.FieldName(i)] = value
+		}
+
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package monitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJSONLinesWriterAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitor.jsonl")
+	conf := NewWriterConfig()
+	conf.ValidWriterName("JSONLinesWriter")
+	conf.DownPath = path
+
+	w, _ := InitWriter(conf)
+	// 两个周期都保留
+	for i := 0; i < 2; i++ {
+		if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+			t.Fatalf("jsonl writer error %s", err)
+		}
+	}
+	w.(*JSONLinesWriter).Close()
+
+	b, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("lines = %d, want 4: %s", len(lines), b)
+	}
+
+	found := false
+	for _, l := range lines {
+		var line jsonLine
+		if err := json.Unmarshal([]byte(l), &line); err != nil {
+			t.Fatalf("bad json line %q: %s", l, err)
+		}
+		if line.Name == "api latency" {
+			found = line.Values["Avg"] == 20 && line.Tags["route"] == "/users" && line.Ts == 1564617600
+		}
+	}
+	if !found {
+		t.Fatalf("api latency line not found: %s", b)
+	}
+}
@@ -0,0 +1,203 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// 分级的 key-value 日志接口及标准库 log 和 log/slog 的适配
+
+var (
+	// Logger 日志输出, 可通过 monitor.Logger 修改
+	// 默认丢弃所有日志
+	Logger LeveledLogger = NewStdLogger(log.New(ioutil.Discard, "[GoMonitor] ", log.LstdFlags), InfoLevel)
+)
+
+// 日志中统一使用的 key
+const (
+	// LogKeyWriter Writer 名
+	LogKeyWriter = "writer"
+	// LogKeyMetric 指标名
+	LogKeyMetric = "metric"
+	// LogKeyID 指标映射的 ID
+	LogKeyID = "id"
+	// LogKeyTs 周期的时间戳
+	LogKeyTs = "ts"
+	// LogKeyErr 错误信息
+	LogKeyErr = "err"
+	// LogKeyPanic recover 到的 panic
+	LogKeyPanic = "panic"
+	// LogKeyCollector 采集函数名
+	LogKeyCollector = "collector"
+)
+
+// Level 日志级别
+type Level int32
+
+const (
+	// DebugLevel 调试
+	DebugLevel Level = iota
+	// InfoLevel 信息
+	InfoLevel
+	// WarnLevel 警告
+	WarnLevel
+	// ErrorLevel 错误
+	ErrorLevel
+)
+
+// String 返回日志级别的名字
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "DEBUG"
+	case InfoLevel:
+		return "INFO"
+	case WarnLevel:
+		return "WARN"
+	case ErrorLevel:
+		return "ERROR"
+	}
+	return fmt.Sprintf("Level(%d)", int32(l))
+}
+
+// ParseLevel 解析日志级别, 不区分大小写
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "DEBUG":
+		return DebugLevel, nil
+	case "INFO":
+		return InfoLevel, nil
+	case "WARN":
+		return WarnLevel, nil
+	case "ERROR":
+		return ErrorLevel, nil
+	}
+	return InfoLevel, &ErrorLoggerLevel{}
+}
+
+// LeveledLogger 分级的 key-value 日志接口
+// kv 为成对出现的 key 和 value, key 建议使用 LogKey* 常量
+type LeveledLogger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+}
+
+// levelFilter 日志最小级别, 可并发修改
+type levelFilter struct {
+	min int32
+}
+
+// SetLevel 修改最小日志级别
+func (f *levelFilter) SetLevel(l Level) {
+	atomic.StoreInt32(&f.min, int32(l))
+}
+
+// enabled 判断该级别是否需要输出
+func (f *levelFilter) enabled(l Level) bool {
+	return int32(l) >= atomic.LoadInt32(&f.min)
+}
+
+// StdLogger 标准库 log 的适配
+// 输出格式为: LEVEL msg key=value key=value
+type StdLogger struct {
+	levelFilter
+	l *log.Logger
+}
+
+// NewStdLogger 返回一个标准库 log 的适配, min 为最小输出的级别
+func NewStdLogger(l *log.Logger, min Level) *StdLogger {
+	s := &StdLogger{l: l}
+	s.SetLevel(min)
+	return s
+}
+
+func (s *StdLogger) output(level Level, msg string, kv []interface{}) {
+	if !s.enabled(level) {
+		return
+	}
+
+	var buf strings.Builder
+	buf.WriteString(level.String())
+	buf.WriteByte(' ')
+	buf.WriteString(msg)
+
+	for i := 0; i < len(kv); i += 2 {
+		buf.WriteByte(' ')
+		if i+1 == len(kv) {
+			fmt.Fprintf(&buf, "!BADKEY=%s", formatLogValue(kv[i]))
+			break
+		}
+		fmt.Fprintf(&buf, "%v=%s", kv[i], formatLogValue(kv[i+1]))
+	}
+
+	s.l.Println(buf.String())
+}
+
+// formatLogValue 格式化日志的 value, 含空白字符时加引号
+func formatLogValue(v interface{}) string {
+	s := fmt.Sprintf("%v", v)
+	if strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// Debug 输出 DEBUG 日志
+func (s *StdLogger) Debug(msg string, kv ...interface{}) { s.output(DebugLevel, msg, kv) }
+
+// Info 输出 INFO 日志
+func (s *StdLogger) Info(msg string, kv ...interface{}) { s.output(InfoLevel, msg, kv) }
+
+// Warn 输出 WARN 日志
+func (s *StdLogger) Warn(msg string, kv ...interface{}) { s.output(WarnLevel, msg, kv) }
+
+// Error 输出 ERROR 日志
+func (s *StdLogger) Error(msg string, kv ...interface{}) { s.output(ErrorLevel, msg, kv) }
+
+// SlogLogger log/slog 的适配
+type SlogLogger struct {
+	levelFilter
+	l *slog.Logger
+}
+
+// NewSlogLogger 返回一个 log/slog 的适配, min 为最小输出的级别
+// slog.Handler 自身的级别同样生效
+func NewSlogLogger(l *slog.Logger, min Level) *SlogLogger {
+	s := &SlogLogger{l: l}
+	s.SetLevel(min)
+	return s
+}
+
+// slogLevels Level 和 slog.Level 的对应关系
+var slogLevels = map[Level]slog.Level{
+	DebugLevel: slog.LevelDebug,
+	InfoLevel:  slog.LevelInfo,
+	WarnLevel:  slog.LevelWarn,
+	ErrorLevel: slog.LevelError,
+}
+
+func (s *SlogLogger) output(level Level, msg string, kv []interface{}) {
+	if !s.enabled(level) {
+		return
+	}
+	s.l.Log(context.Background(), slogLevels[level], msg, kv...)
+}
+
+// Debug 输出 DEBUG 日志
+func (s *SlogLogger) Debug(msg string, kv ...interface{}) { s.output(DebugLevel, msg, kv) }
+
+// Info 输出 INFO 日志
+func (s *SlogLogger) Info(msg string, kv ...interface{}) { s.output(InfoLevel, msg, kv) }
+
+// Warn 输出 WARN 日志
+func (s *SlogLogger) Warn(msg string, kv ...interface{}) { s.output(WarnLevel, msg, kv) }
+
+// Error 输出 ERROR 日志
+func (s *SlogLogger) Error(msg string, kv ...interface{}) { s.output(ErrorLevel, msg, kv) }
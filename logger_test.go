@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestStdLoggerLevelAndFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewStdLogger(log.New(&buf, "", 0), WarnLevel)
+
+	l.Info("dropped")
+	l.Warn("writer fail", LogKeyWriter, "TextWriter", LogKeyErr, "no such file")
+
+	got := buf.String()
+	if strings.Contains(got, "dropped") {
+		t.Fatalf("info log should be filtered: %q", got)
+	}
+	want := `WARN writer fail writer=TextWriter err="no such file"` + "\n"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	l.SetLevel(DebugLevel)
+	l.Debug("odd", "key")
+	if got := buf.String(); got != "DEBUG odd !BADKEY=key\n" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})
+	l := NewSlogLogger(slog.New(h), InfoLevel)
+
+	l.Debug("filtered")
+	l.Error("init writer error", LogKeyWriter, "TextWriter")
+
+	got := buf.String()
+	if strings.Contains(got, "filtered") {
+		t.Fatalf("debug log should be filtered: %q", got)
+	}
+	if !strings.Contains(got, "level=ERROR") || !strings.Contains(got, "writer=TextWriter") {
+		t.Fatalf("unexpected slog output %q", got)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("warn"); err != nil || l != WarnLevel {
+		t.Fatalf("ParseLevel(warn) = %v, %v", l, err)
+	}
+	if _, err := ParseLevel("trace"); err == nil {
+		t.Fatal("ParseLevel(trace) should fail")
+	}
+}
@@ -1,7 +1,8 @@
 package monitor
 
 import (
-	"runtime"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -21,28 +22,68 @@ func (m *MONITOR) getCallNameMaeID(CallName string) (id int, ok bool) {
 	return -1, false
 }
 
+// lookupMetric 获取 key 已注册的 ID, 已注册的类型不是 metricType 时返回 ErrMetricConflict
+// want 不为 nil 时可选配置也需一致, 参考 conflictMetric
+func (m *MONITOR) lookupMetric(key string, metricType int, want *MetricName) (id int, ok bool, err error) {
+	m.Core.MetricMap.RLock()
+	defer m.Core.MetricMap.RUnlock()
+
+	if id, ok = m.Core.MetricMap.CallNameMap[key]; !ok {
+		return -1, false, nil
+	}
+	if err = conflictMetric(m.Core.MetricMap.Map[id], metricType, want); err != nil {
+		return -1, true, err
+	}
+	return id, true, nil
+}
+
 // initNewMetricName 初始化一个指标的映射,并初始化当前监控中特殊类型的值
 // 返回初始化是用到的 ID
 func (m *MONITOR) initNewMetricName(_name string, _type int, _desc string,
 	tags map[string]string) (_id int, err error) {
+	return m.initNewMetricKey(metricKey(_name, nil), _name, _type, _desc, tags)
+}
+
+// initNewMetricKey 同 initNewMetricName, 映射时使用 key 而不是指标名
+// 带 tags 的指标使用 metricKey 生成的 key, 同名不同 tags 的指标互不影响
+func (m *MONITOR) initNewMetricKey(key string, _name string, _type int, _desc string,
+	tags map[string]string, opts ...MetricOption) (_id int, err error) {
 
 	// 初始化指标名 struct
-	mStruct := initMetricName(_name, _type, _desc, tags)
-	vStruct, err := initSpecValue(_type)
+	mStruct, err := initMetricName(_name, _type, _desc, tags, opts...)
+	if err != nil {
+		return -1, err
+	}
+	vStruct, err := initSpecValue(mStruct)
+	if err != nil {
+		return -1, err
+	}
 
 	m.Core.MetricMap.Lock()
+	// 并发初始化时, 其它协程可能已经完成了初始化, 同样需要校验类型及配置
+	if id, ok := m.Core.MetricMap.CallNameMap[key]; ok {
+		err = conflictMetric(m.Core.MetricMap.Map[id], _type, wantOptions(mStruct, opts))
+		m.Core.MetricMap.Unlock()
+		if err != nil {
+			return -1, err
+		}
+		return id, nil
+	}
 	// 获得一个ID
 	m.Core.MetricMap.LastID++
 	_id = m.Core.MetricMap.LastID
 	// 初始化 name 和 id 的 映射
-	m.Core.MetricMap.CallNameMap[_name] = _id
+	m.Core.MetricMap.CallNameMap[key] = _id
 	// 初始化指标名类型
+	mStruct.Created = m.Core.Clock.Now()
 	m.Core.MetricMap.Map[_id] = mStruct
 
 	// 初始化当前监控数据
+	m.Core.RLock()
 	m.Core.NowMonitor.Lock()
 	m.Core.NowMonitor.PersistentData[_id] = vStruct
 	m.Core.NowMonitor.Unlock()
+	m.Core.RUnlock()
 
 	// map 的 锁释放放到最后, 防止当前监控还没添加上其它协程获取到 ID
 	m.Core.MetricMap.Unlock()
@@ -50,55 +91,152 @@ func (m *MONITOR) initNewMetricName(_name string, _type int, _desc string,
 	return
 }
 
-// Add Set AddPersistent SetPersistent  RecordFuncCount RecordFuncTimes RecordFuncTimeAvg
+// wantOptions 有可选配置时返回 metric 用于比较配置, 否则返回 nil 只比较类型
+func wantOptions(metric *MetricName, opts []MetricOption) *MetricName {
+	if len(opts) == 0 {
+		return nil
+	}
+	return metric
+}
+
+// metricKey 生成指标映射的 key
+// 无 tags 时为指标名, 否则为 指标名;k1=v1;k2=v2, tags 按 key 排序
+// 指标名及 tag 中的 ; = 及 \ 经过转义, 不同的指标名及 tags 不会生成相同的 key
+func metricKey(name string, tags map[string]string) string {
+	if len(tags) == 0 && !strings.ContainsAny(name, tagSpecialChars) {
+		return name
+	}
+
+	var buf strings.Builder
+	writeTagEscaped(&buf, name)
+	if len(tags) > 0 {
+		buf.WriteByte(Semicolon)
+		writeTagsKey(&buf, tags)
+	}
+	return buf.String()
+}
+
+// writeTagsKey 将 tags 按 key 排序写为 k1=v1;k2=v2, 并转义 key 及 value 中的分隔符
+func writeTagsKey(buf *strings.Builder, tags map[string]string) {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(Semicolon)
+		}
+		writeTagEscaped(buf, k)
+		buf.WriteByte(Equal)
+		writeTagEscaped(buf, tags[k])
+	}
+}
+
+// tagSpecialChars key 中需要转义的字符
+const tagSpecialChars = ";=\\"
+
+// writeTagEscaped 写入 s, 在 ; = 及 \ 前加 \
+func writeTagEscaped(buf *strings.Builder, s string) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case Semicolon, Equal, '\\':
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(s[i])
+	}
+}
+
+// RegisterMetric 注册一个特殊指标, 返回的 ID 用于 AddPersistent 及 SetPersistent
+// 同名不同 tags 的为不同的指标, 已存在时直接返回已有的 ID
+// 已存在的指标类型不同, 或传入了 opts 且与第一次注册的配置不同时, 返回 ErrMetricConflict
+// opts 为指标的可选配置, 如 WithQuantiles WithCompression
+func (m *MONITOR) RegisterMetric(name string, metricType int, desc string,
+	tags map[string]string, opts ...MetricOption) (int, error) {
+
+	key := metricKey(name, tags)
+	var want *MetricName
+	if len(opts) > 0 {
+		var err error
+		if want, err = initMetricName(name, metricType, desc, tags, opts...); err != nil {
+			return -1, err
+		}
+	}
+	if id, ok, err := m.lookupMetric(key, metricType, want); ok {
+		return id, err
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	return m.initNewMetricKey(key, name, metricType, desc, tags, opts...)
+}
+
+// Add Set AddPersistent SetPersistent  RecordFuncCount RecordFuncTimes RecordFuncTimeAvg RecordFuncTimer
+// 记录时持有 Storage 的读锁, 版本切换等待正在进行的记录完成, 记录不会丢失在切换出来的版本中
 
 // Add 调用一分钟存储的 Add 实现
 func (m *MONITOR) Add(name string, value float64) {
+	defer m.recoverRecord("Add")
+	m.Core.RLock()
+	defer m.Core.RUnlock()
 	m.Core.NowMonitor.Add(name, value)
 }
 
 // Set 调用一分钟存储的 Set 实现
 func (m *MONITOR) Set(name string, value float64) {
+	defer m.recoverRecord("Set")
+	m.Core.RLock()
+	defer m.Core.RUnlock()
 	m.Core.NowMonitor.Set(name, value)
 }
 
 // AddPersistent 调用一分钟存储的 AddPersistent 实现
 func (m *MONITOR) AddPersistent(MapID int, metricType int, value float64) {
+	defer m.recoverRecord("AddPersistent")
+	m.Core.RLock()
+	defer m.Core.RUnlock()
 	m.Core.NowMonitor.AddPersistent(MapID, metricType, value)
 }
 
 // SetPersistent 调用一分钟存储的 SetPersistent 实现
 func (m *MONITOR) SetPersistent(MapID int, metricType int, value float64) {
+	defer m.recoverRecord("SetPersistent")
+	m.Core.RLock()
+	defer m.Core.RUnlock()
 	m.Core.NowMonitor.SetPersistent(MapID, metricType, value)
 }
 
 // 特殊 RecordFunc 方法
 
-// RecordFuncTimes 记录函数的调用次数
-func (m *MONITOR) RecordFuncTimes() func() {
-	// 使用 runtime 等返回函数调用堆栈 然后记录, caller 参数 1 可能有问题,需测试 TODO
-	start := time.Now()
-	pc, _, _, _ := runtime.Caller(1)
-	callFuncName := runtime.FuncForPC(pc).Name()
-	return func() {
-		m.Add(callFuncName, time.Now().Sub(start).Seconds()*1000)
-	}
+// nop 空函数, Record 类方法出错时返回
+func nop() {}
+
+// RecordFuncTimes 记录函数的调用次数及耗时, 同 RecordFuncTimer
+// 调用次数为耗时指标的 _Count, 之前将毫秒数累加到普通指标中, 结果既不是次数也不是耗时
+func (m *MONITOR) RecordFuncTimes() (done func()) {
+	return m.recordFuncTimer("RecordFuncTimes")
 }
 
 // RecordFuncTimeAvg 记录函数调用的平均时间消耗
 // 特殊数据中记录总数, 最后落地前计算平均值
 // 未使用 RecordMetircTimeAvg 作为底层是为了将获取函数名的时间也计入开销
-func (m *MONITOR) RecordFuncTimeAvg() func() {
+func (m *MONITOR) RecordFuncTimeAvg() (done func()) {
+	// 初始化过程中 panic 时返回空函数, 保证 defer 调用安全
+	done = nop
+	defer m.recoverRecord("RecordFuncTimeAvg")
+
 	start := time.Now()
-	pc, _, _, _ := runtime.Caller(1)
-	callFuncName := runtime.FuncForPC(pc).Name()
+	callFuncName := callerFuncName(1)
 
-	id := -1
-	id, ok := m.getCallNameMaeID(callFuncName)
-	if !ok {
-		tags := make(map[string]string)
-		id, _ = m.initNewMetricName(callFuncName, AvgMetric,
-			"Record a func time avg metric", tags)
+	// 函数名已注册为其它类型时, 如同一函数还使用了 RecordFuncTimer, 改用带后缀的名字
+	id, err := m.lookupAvgMetric(callFuncName)
+	if _, ok := err.(*ErrMetricConflict); ok {
+		id, err = m.lookupAvgMetric(callFuncName + FuncTimeAvgSuffix)
+	}
+	if err != nil {
+		Logger.Warn("record func time avg error", LogKeyMetric, callFuncName, LogKeyErr, err)
+		return
 	}
 
 	return func() {
@@ -106,16 +244,36 @@ func (m *MONITOR) RecordFuncTimeAvg() func() {
 	}
 }
 
+// avgMetricID 返回以 name 注册的 AvgMetric 的 ID, 未注册时注册
+// name 已注册为其它类型时记录日志并返回错误, 调用方不记录, 避免按错误的类型记录而 panic
+func (m *MONITOR) avgMetricID(name string) (int, error) {
+	id, err := m.lookupAvgMetric(name)
+	if err != nil {
+		Logger.Warn("record func time avg error", LogKeyMetric, name, LogKeyErr, err)
+	}
+	return id, err
+}
+
+// lookupAvgMetric 同 avgMetricID, 不记录日志
+func (m *MONITOR) lookupAvgMetric(name string) (int, error) {
+	id, ok, err := m.lookupMetric(metricKey(name, nil), AvgMetric, nil)
+	if !ok {
+		id, err = m.initNewMetricName(name, AvgMetric, "Record a func time avg metric", make(map[string]string))
+	}
+	return id, err
+}
+
 // RecordMetircTimeAvg 对给定对指标求平均值
 // 需要给出指标名, 除此之外,其余的都与 RecordFuncTimeAvg 相同
-func (m *MONITOR) RecordMetircTimeAvg(CallName string) func() {
+func (m *MONITOR) RecordMetircTimeAvg(CallName string) (done func()) {
+	// 初始化过程中 panic 时返回空函数, 保证 defer 调用安全
+	done = nop
+	defer m.recoverRecord("RecordMetircTimeAvg")
+
 	start := time.Now()
-	id := -1
-	id, ok := m.getCallNameMaeID(CallName)
-	if !ok {
-		tags := make(map[string]string)
-		id, _ = m.initNewMetricName(CallName, AvgMetric,
-			"Record a func time avg metric", tags)
+	id, err := m.avgMetricID(CallName)
+	if err != nil {
+		return
 	}
 
 	return func() {
@@ -124,10 +282,12 @@ func (m *MONITOR) RecordMetircTimeAvg(CallName string) func() {
 }
 
 // RecordFuncCount 记录函数的调用次数
-func (m *MONITOR) RecordFuncCount() func() {
-	// 使用 runtime 等返回函数调用堆栈 然后记录, caller 参数 1 可能有问题,需测试 TODO
-	pc, _, _, _ := runtime.Caller(1)
-	callFuncName := runtime.FuncForPC(pc).Name()
+func (m *MONITOR) RecordFuncCount() (done func()) {
+	// 初始化过程中 panic 时返回空函数, 保证 defer 调用安全
+	done = nop
+	defer m.recoverRecord("RecordFuncCount")
+
+	callFuncName := callerFuncName(1)
 	return func() {
 		m.Add(callFuncName, 1.0)
 	}
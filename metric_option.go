@@ -0,0 +1,152 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 单个指标的可选配置, 在 RegisterMetric 时传入
+// 如: 分位数指标输出的分位点及 t-digest 的压缩参数
+
+// MetricOption 指标的可选配置
+type MetricOption func(m *MetricName) error
+
+// ErrMetricOption 指标配置错误
+type ErrMetricOption struct {
+	Name string // 指标名
+	Msg  string // 信息
+}
+
+// Error 实现 error 接口
+func (e *ErrMetricOption) Error() string {
+	return fmt.Sprintf("Metric %s option error: %s", e.Name, e.Msg)
+}
+
+// WithQuantiles 分位数及耗时指标输出的分位点, 取值范围 (0, 1), 如 0.1 0.999
+// 未配置时为 0.5 0.9 0.95 0.99, 后缀相同的分位点如 0.15 0.015 返回错误
+func WithQuantiles(quantiles ...float64) MetricOption {
+	return func(m *MetricName) error {
+		if m.Type != QuantileMetric && m.Type != TimerMetric {
+			return &ErrMetricOption{Name: m.Name, Msg: "quantiles only for QuantileMetric or TimerMetric"}
+		}
+		if len(quantiles) == 0 {
+			return &ErrMetricOption{Name: m.Name, Msg: "quantiles is empty"}
+		}
+
+		qs := make([]float64, 0, len(quantiles))
+		for _, q := range quantiles {
+			if !(q > 0 && q < 1) {
+				return &ErrMetricOption{Name: m.Name, Msg: fmt.Sprintf("quantile %v not in (0, 1)", q)}
+			}
+			qs = append(qs, q)
+		}
+		sort.Float64s(qs)
+
+		// 去重
+		uniq := qs[:1]
+		for _, q := range qs[1:] {
+			if q != uniq[len(uniq)-1] {
+				uniq = append(uniq, q)
+			}
+		}
+
+		// 后缀去掉了小数点, 不同的分位点可能生成相同的后缀, 输出时字段重复
+		suffixes := make(map[string]float64, len(uniq))
+		for _, q := range uniq {
+			suffix := quantileSuffix(q)
+			if prev, ok := suffixes[suffix]; ok {
+				return &ErrMetricOption{Name: m.Name, Msg: fmt.Sprintf("quantiles %v and %v have the same suffix %s", prev, q, suffix)}
+			}
+			suffixes[suffix] = q
+		}
+		m.Quantiles = uniq
+		return nil
+	}
+}
+
+// WithCompression 分位数及耗时指标 t-digest 的压缩参数, 越大越精确, 占用内存越多, 需 >= 1
+// 未配置时为 t-digest 的默认值 100
+func WithCompression(compression float64) MetricOption {
+	return func(m *MetricName) error {
+		if m.Type != QuantileMetric && m.Type != TimerMetric {
+			return &ErrMetricOption{Name: m.Name, Msg: "compression only for QuantileMetric or TimerMetric"}
+		}
+		if !(compression >= 1) {
+			return &ErrMetricOption{Name: m.Name, Msg: "compression must >= 1"}
+		}
+		m.Compression = compression
+		return nil
+	}
+}
+
+// quantileSuffix 返回分位点的后缀, 如 0.5 为 _MinP50, 0.999 为 _MinP999, 0.001 为 _MinP01
+func quantileSuffix(q float64) string {
+	percent := strconv.FormatFloat(math.Round(q*1e8)/1e6, 'f', -1, 64)
+	return "_MinP" + strings.Replace(percent, ".", "", 1)
+}
+
+// quantileSuffixes 返回分位数指标的后缀, 各分位点之后为 _Min _Max _Count _Sum
+func quantileSuffixes(quantiles []float64) []string {
+	suffix := make([]string, 0, len(quantiles)+len(quantileStatSuffix))
+	for _, q := range quantiles {
+		suffix = append(suffix, quantileSuffix(q))
+	}
+	return append(suffix, quantileStatSuffix...)
+}
+
+// WithCumulative 在每个周期的增量之外输出进程启动以来的累计值 _CountTotal _SumTotal
+// 累计值在周期切换时延续, 进程重启后从 0 开始, 通过 Point 的 Start 判断重置; gauge 去重计数及 Top-K 类型不支持
+func WithCumulative() MetricOption {
+	return func(m *MetricName) error {
+		switch m.Type {
+		case GaugeMetric, CardinalityMetric, TopKMetric:
+			return &ErrMetricOption{Name: m.Name, Msg: "cumulative not for GaugeMetric, CardinalityMetric or TopKMetric"}
+		}
+		m.Cumulative = true
+		return nil
+	}
+}
+
+// metricOptions 指标可选配置的集合, 用于比较两次注册的配置是否一致
+type metricOptions struct {
+	Quantiles    []float64
+	Compression  float64
+	Buckets      []float64
+	Cumulative   bool
+	HLLPrecision int
+	TopK         int
+	TopKCapacity int
+	UnitSize     time.Duration
+}
+
+// options 返回指标的可选配置
+func (m *MetricName) options() metricOptions {
+	return metricOptions{
+		Quantiles:    m.Quantiles,
+		Compression:  m.Compression,
+		Buckets:      m.Buckets,
+		Cumulative:   m.Cumulative,
+		HLLPrecision: m.HLLPrecision,
+		TopK:         m.TopK,
+		TopKCapacity: m.TopKCapacity,
+		UnitSize:     m.UnitSize,
+	}
+}
+
+// conflictMetric 校验已注册的指标 registered 与本次注册的类型一致, want 不为 nil 时可选配置也需一致
+// 不一致时返回 ErrMetricConflict, 避免按错误的类型记录到已有的 SpecValue 中
+func conflictMetric(registered *MetricName, metricType int, want *MetricName) error {
+	if registered.Type != metricType {
+		return &ErrMetricConflict{Name: registered.Name,
+			Msg: fmt.Sprintf("registered as type %d, not %d", registered.Type, metricType)}
+	}
+	if want != nil && !reflect.DeepEqual(registered.options(), want.options()) {
+		return &ErrMetricConflict{Name: registered.Name, Msg: "registered with different options"}
+	}
+	return nil
+}
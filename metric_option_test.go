@@ -0,0 +1,91 @@
+package monitor
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestQuantileSuffix(t *testing.T) {
+	for q, want := range map[float64]string{
+		0.5: "_MinP50", 0.9: "_MinP90", 0.99: "_MinP99", 0.999: "_MinP999", 0.1: "_MinP10", 0.001: "_MinP01", 0.05: "_MinP5",
+	} {
+		if got := quantileSuffix(q); got != want {
+			t.Errorf("quantileSuffix(%v) = %s, want %s", q, got, want)
+		}
+	}
+
+	// 默认分位点生成的后缀与 QuantileSuffix 一致
+	if got := quantileSuffixes(defaultQuantiles); !reflect.DeepEqual(got, QuantileSuffix) {
+		t.Fatalf("default suffixes %v, want %v", got, QuantileSuffix)
+	}
+}
+
+func TestRegisterMetricWithQuantiles(t *testing.T) {
+	m, _ := New(NewConfig())
+	id, err := m.RegisterMetric("rpc.latency", QuantileMetric, "", nil,
+		WithQuantiles(0.999, 0.1, 0.5, 0.5), WithCompression(200))
+	if err != nil {
+		t.Fatalf("register error %s", err)
+	}
+	for i := 1; i <= 1000; i++ {
+		m.AddPersistent(id, QuantileMetric, float64(i))
+	}
+
+	metric := m.Core.MetricMap.Map[id]
+	if m.Core.NowMonitor.PersistentData[id].Otd.Compression() != 200 {
+		t.Fatalf("compression not applied")
+	}
+
+	omd := m.Core.NextMonitor()
+	// 下一个周期的值使用同样的配置初始化
+	if m.Core.NowMonitor.PersistentData[id].Otd.Compression() != 200 {
+		t.Fatalf("compression not applied after rotation")
+	}
+
+	var p *Point
+	for _, point := range collectPoints(m.Core.MetricMap, omd) {
+		if point.Name == "rpc.latency" {
+			p = point
+		}
+	}
+
+	wantSuffix := []string{"_MinP10", "_MinP50", "_MinP999", "_Min", "_Max", "_Count", "_Sum"}
+	if !reflect.DeepEqual(metric.Suffixes(), wantSuffix) || !reflect.DeepEqual(p.Suffix, wantSuffix) {
+		t.Fatalf("suffix %v, want %v", p.Suffix, wantSuffix)
+	}
+	if !reflect.DeepEqual(p.Quantiles, []float64{0.1, 0.5, 0.999}) {
+		t.Fatalf("quantiles %v", p.Quantiles)
+	}
+
+	for field, want := range map[string]float64{"MinP10": 100, "MinP50": 500, "MinP999": 999, "Min": 1, "Max": 1000, "Count": 1000, "Sum": 500500} {
+		got, ok := p.Field(field)
+		if !ok || math.Abs(got-want) > want*0.01 {
+			t.Errorf("%s = %v, want %v", field, got, want)
+		}
+	}
+}
+
+func TestRegisterMetricOptionError(t *testing.T) {
+	m, _ := New(NewConfig())
+	for _, opt := range []MetricOption{WithQuantiles(), WithQuantiles(1), WithQuantiles(0), WithQuantiles(0.15, 0.015), WithCompression(0.5)} {
+		if _, err := m.RegisterMetric("bad", QuantileMetric, "", nil, opt); err == nil {
+			t.Fatalf("expect option error")
+		}
+	}
+	if _, err := m.RegisterMetric("count", CountMetric, "", nil, WithQuantiles(0.5)); err == nil {
+		t.Fatalf("expect error for quantiles on CountMetric")
+	}
+	if len(m.Core.MetricMap.Map) != 0 {
+		t.Fatalf("failed registration should not add metric")
+	}
+}
+
+func TestQuantileEmptyMinMax(t *testing.T) {
+	metric, _ := initMetricName("empty", QuantileMetric, "", nil)
+	sv, _ := initSpecValue(metric)
+	values := getValues(metric, sv)
+	if len(values) != len(QuantileSuffix) || !math.IsNaN(values[4]) || !math.IsNaN(values[5]) || values[6] != 0 {
+		t.Fatalf("unexpected empty values %v", values)
+	}
+}
@@ -1,6 +1,8 @@
 package monitor
 
 import (
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -8,10 +10,11 @@ import (
 // 一分钟的存储定义及方法
 
 // OneMinStorage 一分钟的指标存储
-// Ts 先占位, 之后可能会有需要返回其创建是的分钟或其它
+// Ts 为该周期的开始时间, 周期切换后对齐到周期边界
 type OneMinStorage struct {
 	sync.RWMutex
-	Ts             time.Time          // 初始化时候时间
+	Ts             time.Time          // 周期开始时间
+	End            time.Time          // 周期结束时间, 切换时设置, 当前周期为零值
 	PersistentData map[int]*SpecValue // 监控数据
 	Data           map[string]float64 // 其它监控
 }
@@ -28,17 +31,19 @@ func NewOneMinStorage() *OneMinStorage {
 // Add 针对一个具体指标名添加一个 float64 的值
 func (oms *OneMinStorage) Add(name string, value float64) {
 	oms.Lock()
+	defer oms.Unlock()
 	oms.Data[name] += value
-	oms.Unlock()
 }
 
 // AddPersistent 针对一个持久化的指标名添加一个 float64 的值
+// Gauge 类型的 value 为增量, 在最后值的基础上增加后记录一次取值
 func (oms *OneMinStorage) AddPersistent(MapID int, metricType int, value float64) {
 	oms.Lock()
 	defer oms.Unlock()
 
 	if sv, ok := oms.PersistentData[MapID]; ok {
 		sv.Lock()
+		defer sv.Unlock()
 
 		switch metricType {
 		case BaseMetric:
@@ -56,36 +61,59 @@ func (oms *OneMinStorage) AddPersistent(MapID int, metricType int, value float64
 		case CountAvgMetric:
 			sv.Sum += value
 			sv.Count++
-		case QuantileMetric:
+		case QuantileMetric, TimerMetric:
 			sv.Otd.Add(value)
+			sv.observeMinMax(value)
+			sv.Sum += value
+			sv.Count++
+		case HistogramMetric:
+			sv.observe(value)
+			sv.Sum += value
+			sv.Count++
+		case GaugeMetric:
+			sv.setGauge(sv.Last + value)
+		case RateMetric:
+			sv.Sum += value
+			sv.Count++
+		case CardinalityMetric:
+			// 数值 key 按最短的十进制表示记录, 与 AddCardinality 记录同样的字符串一致
+			sv.HLL.AddString(strconv.FormatFloat(value, 'g', -1, 64))
+			sv.Count++
+		case TopKMetric:
+			// 数值 key 同去重计数, 每次计数加 1
+			sv.TopK.Add(strconv.FormatFloat(value, 'g', -1, 64), 1)
+			sv.Sum++
+			sv.Count++
 		}
 
-		sv.Unlock()
 		return
 	}
 
-	Logger.Printf("AddPersistent Not Have MapID: %d, func break", MapID)
+	oms.Data[SelfDroppedRecords]++
+	Logger.Warn("add persistent not have map id", LogKeyID, MapID, LogKeyTs, oms.Ts.Unix())
 }
 
 // Set 针对一个具体指标名添加一个 float64 的值
 // 暂时用锁实现
 func (oms *OneMinStorage) Set(name string, value float64) {
 	oms.Lock()
+	defer oms.Unlock()
 	oms.Data[name] = value
-	oms.Unlock()
 }
 
 // SetPersistent 针对一个持久化的指标名添加一个 float64 的值
-// 分位数不支持 Set 方法
+// 分位数及直方图不支持 Set 方法
 // 基础的和Sum类型则直接使用 value 值
 // Avg 方法使用 value 方法同时将 Count 置为 1
 // Count 忽略 value, 直接将 Count 类型置为 1
+// Gauge 记录一次取值, 更新 最后值 最小值 最大值 及平均值
 func (oms *OneMinStorage) SetPersistent(MapID int, metricType int, value float64) {
 	oms.Lock()
 	defer oms.Unlock()
 
 	if sv, ok := oms.PersistentData[MapID]; ok {
 		sv.Lock()
+		defer sv.Unlock()
 
 		switch metricType {
 		case BaseMetric:
@@ -103,15 +131,26 @@ func (oms *OneMinStorage) SetPersistent(MapID int, metricType int, value float64
 		case CountAvgMetric:
 			sv.Sum = value
 			sv.Count = 1
-		case QuantileMetric:
-			Logger.Println("QuantileMetric Metric Cant Use Set Method")
+		case QuantileMetric, TimerMetric:
+			Logger.Warn("quantile metric cant use set method", LogKeyID, MapID, LogKeyTs, oms.Ts.Unix())
+		case HistogramMetric:
+			Logger.Warn("histogram metric cant use set method", LogKeyID, MapID, LogKeyTs, oms.Ts.Unix())
+		case CardinalityMetric:
+			Logger.Warn("cardinality metric cant use set method", LogKeyID, MapID, LogKeyTs, oms.Ts.Unix())
+		case TopKMetric:
+			Logger.Warn("top k metric cant use set method", LogKeyID, MapID, LogKeyTs, oms.Ts.Unix())
+		case GaugeMetric:
+			sv.setGauge(value)
+		case RateMetric:
+			sv.Sum = value
+			sv.Count = 1
 		}
 
-		sv.Unlock()
 		return
 	}
 
-	Logger.Printf("Set Not Have MapID: %d, func break", MapID)
+	oms.Data[SelfDroppedRecords]++
+	Logger.Warn("set persistent not have map id", LogKeyID, MapID, LogKeyTs, oms.Ts.Unix())
 }
 
 // Get 获取某个监控指标的 value
@@ -138,6 +177,17 @@ func (oms *OneMinStorage) Len() int {
 	return len(oms.Data) + len(oms.PersistentData)
 }
 
+// seriesLen 获取当前分钟业务指标的个数, 不包括 SelfMonitorKey 下的自身监控指标
+func (oms *OneMinStorage) seriesLen() int {
+	n := len(oms.PersistentData)
+	for name := range oms.Data {
+		if !strings.HasPrefix(name, SelfMonitorKey) {
+			n++
+		}
+	}
+	return n
+}
+
 // GetAll 用在落地 or 上传的时候 TODO
 // 可能需要在给出对应的映射关系, 先占位
 func (oms *OneMinStorage) GetAll() {
@@ -1,32 +1,29 @@
 package monitor
 
 import (
-	"io/ioutil"
-	"log"
+	"io"
+	"net"
 	"sync"
 	"time"
 	// _ "net/http/pprof"
 )
 
-var (
-	// Logger 日志输出, 可通过 monitor.Logger 修改
-	Logger StdLogger = log.New(ioutil.Discard, "[GoMonitor] ", log.LstdFlags)
-)
-
-// StdLogger is used to log error messages.
-type StdLogger interface {
-	Print(v ...interface{})
-	Printf(format string, v ...interface{})
-	Println(v ...interface{})
-}
-
 // MONITOR 模块本身
 type MONITOR struct {
 	sync.RWMutex          // 保护Client状态
 	Conf         *Config  // 配置文件
 	Core         *Storage // 核心存储
 
-	writers []Writer // 数据后续处理接口
+	writers     []Writer       // 数据后续处理接口
+	writerNames []string       // 与 writers 一一对应的 Writer 名, 用于自身监控
+	collectors  []collector    // 切换版本前执行的采集函数, 参考 AddCollector
+	writing     sync.WaitGroup // 正在执行的 Writer, Stop 时等待完成后关闭 Writer
+
+	statsdConn net.PacketConn // statsd 接收端的连接
+
+	trackMu     sync.RWMutex              // 保护 trackTimers
+	trackTimers map[trackKey]*MetricTimer // Track 使用的耗时指标缓存
+	httpSeries  httpSeriesCache           // HTTP 中间件及 RoundTripper 的指标缓存
 
 	closer, closed chan struct{} // 用于关闭后台落地文件的程序 发送数据 export 等
 }
@@ -43,20 +40,37 @@ func New(conf *Config) (*MONITOR, error) {
 	m.Conf = conf
 
 	// 核心初始化
+	if conf.Clock == nil {
+		conf.Clock = RealClock
+	}
 	m.Core = NewStorage(conf.Revisions)
+	m.Core.Interval = conf.Interval
+	m.Core.Clock = conf.Clock
+	m.Core.NowMonitor.Ts = conf.Clock.Now()
 
 	for _, wc := range conf.Writers {
 		writer, err := InitWriter(wc)
 		if err != nil {
-			Logger.Printf("Init Writer Error %s", err)
+			Logger.Error("init writer error", LogKeyWriter, wc.Name, LogKeyErr, err)
 			continue
 		}
 		m.writers = append(m.writers, writer)
+		m.writerNames = append(m.writerNames, wc.Name)
 	}
 
 	return m, nil
 }
 
+// AddWriter 直接添加一个 Writer 实例, 不经过 RegisterWriterName 注册
+// 用于测试中的内存 Writer 等无需配置的 Writer, 应在 Start 之前调用
+func (m *MONITOR) AddWriter(name string, writer Writer) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.writers = append(m.writers, writer)
+	m.writerNames = append(m.writerNames, name)
+}
+
 // HTTPPort 校验并更新配置中的 HTTP 端口
 func (m *MONITOR) HTTPPort(port int) error {
 	return m.Conf.ValidateHTTPPort(port)
@@ -75,65 +89,126 @@ func (m *MONITOR) Revisions(n int) error {
 }
 
 // Start 启动监控
-// 有必要增加 recover 方式启动, 以使监控系统出问题的时候不影响业务
+// 后台循环及记录方法均已 recover, 监控系统出问题的时候不影响业务
 // 启动 HTTP Listen  TODO ???
 // 启动周期性的执行 NextMonitor, 并将返回结果给 []writer 处理, 需要 recover
-// 周期为 60s ,按照实际分钟的 0s 开始处理, 其它直接周期处理
+// 任意周期都按照墙上时钟的周期边界处理, 如 60s 在每分钟的 0s, 5m 在 0/5/10 分, 不阻塞调用方
 func (m *MONITOR) Start() {
-	var t *time.Ticker
-
 	m.StartHTTPModule(m.Conf.Port)
-
-	if int(m.Conf.Interval.Seconds()) == 60 {
-		startInWholeMinute()
-		t = time.NewTicker(m.Conf.Interval)
-	} else {
-		t = time.NewTicker(m.Conf.Interval)
+	if m.Conf.StatsdPort > 0 {
+		m.StartStatsdListener(m.Conf.StatsdPort)
 	}
 
-	// ticker 启动前执行一次
-	now := m.Core.NextMonitor()
-	for _, writer := range m.writers {
-		go writer.DoWithRecover(m.Core.MetricMap, now)
-	}
+	m.Core.Interval = m.Conf.Interval
+
+	// 周期启动前执行一次, 之后的版本 Ts 对齐到周期边界
+	m.rotate()
 
 	// 周期执行
-	go func() {
-		defer close(m.closed)
-		defer t.Stop()
-
-		for {
-			select {
-			case <-t.C:
-				// 周期性执行 NextMonitor 并将结果交给 Writer 处理
-				now := m.Core.NextMonitor()
-				for _, writer := range m.writers {
-					go writer.DoWithRecover(m.Core.MetricMap, now)
-				}
-			case <-m.closer:
-				Logger.Println("Monitor Recv Close Single, quit...")
-				return
-			}
+	go m.loop()
+}
+
+// loop 后台周期执行, 每个周期边界切换一次版本
+// 每次切换后根据当前时间重新计算下一个边界, 避免 ticker 的累积漂移
+func (m *MONITOR) loop() {
+	defer close(m.closed)
+
+	interval := m.Conf.Interval
+	clock := m.Core.Clock
+	now := clock.Now()
+	t := clock.NewTimer(nextBoundary(now, interval).Sub(now))
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C():
+			// 周期性执行 NextMonitor 并将结果交给 Writer 处理
+			m.rotate()
+
+			now = clock.Now()
+			t.Reset(nextBoundary(now, interval).Sub(now))
+		case <-m.closer:
+			Logger.Info("monitor recv close signal, quit")
+			return
+		}
+	}
+}
+
+// rotate 切换监控版本并将结果交给 Writer 异步处理
+// panic 会被 recover 并记录, 后台循环继续执行
+func (m *MONITOR) rotate() {
+	m.rotateAndWrite(false)
+}
+
+// Tick 同步切换一次监控版本, 等待所有 Writer 处理完成后返回切换出来的版本
+// 配合 FakeClock 使用, 测试中无需等待真实的周期
+func (m *MONITOR) Tick() *OneMinStorage {
+	return m.rotateAndWrite(true)
+}
+
+// rotateAndWrite 切换监控版本并交给 Writer 处理, wait 为 true 时等待 Writer 完成
+// 切换前执行采集函数, 采集的值记录在切换出来的版本中
+func (m *MONITOR) rotateAndWrite(wait bool) (now *OneMinStorage) {
+	defer func() {
+		if p := recover(); p != nil {
+			m.addSelf(SelfLoopPanics, 1)
+			Logger.Error("monitor rotate panic", LogKeyPanic, p)
 		}
 	}()
+
+	m.runCollectors()
+	now = m.Core.NextMonitor()
+
+	var wg sync.WaitGroup
+	for i, writer := range m.writers {
+		wg.Add(1)
+		m.writing.Add(1)
+		go func(name string, writer Writer) {
+			defer m.writing.Done()
+			defer wg.Done()
+			m.doWrite(name, writer, now)
+		}(m.writerNames[i], writer)
+	}
+
+	if wait {
+		wg.Wait()
+	}
+	return
 }
 
 // Stop 停止监控.  可能采用其它逻辑 TODO
 func (m *MONITOR) Stop() {
-	Logger.Println("Will Stop Monitor...")
+	Logger.Info("will stop monitor")
 	close(m.closer)
+	m.stopStatsdListener()
 
 	<-m.closed
+	m.closeWriters()
+}
+
+// closeWriters 等待正在执行的 Writer 完成后, 关闭实现了 io.Closer 的 Writer, 如轮转文件
+func (m *MONITOR) closeWriters() {
+	m.writing.Wait()
+
+	for i, writer := range m.writers {
+		if c, ok := writer.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				Logger.Error("close writer error", LogKeyWriter, m.writerNames[i], LogKeyErr, err)
+			}
+		}
+	}
+}
+
+// alignTime 将时间对齐到所在周期的开始
+// 以 UTC 零点为基准对齐, 不同主机同一周期的 Ts 一致; interval <= 0 时不对齐
+func alignTime(t time.Time, interval time.Duration) time.Time {
+	if interval <= 0 {
+		return t
+	}
+	return t.Truncate(interval)
 }
 
-// startInWholeMinute 阻塞, 直到分钟为整
-func startInWholeMinute() {
-	now := time.Now()
-	// 计算下一个整数分钟
-	next := now.Add(time.Second * 60)
-	next = time.Date(next.Year(), next.Month(), next.Day(),
-		next.Hour(), next.Minute(), 0, 0, next.Location())
-	// 整分钟后触发, 结束函数
-	t := time.NewTimer(next.Sub(now))
-	<-t.C
+// nextBoundary 返回 t 之后的下一个周期边界
+func nextBoundary(t time.Time, interval time.Duration) time.Time {
+	return alignTime(t, interval).Add(interval)
 }
@@ -0,0 +1,72 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlignTime(t *testing.T) {
+	ts := time.Date(2019, 8, 1, 10, 17, 43, 500, time.UTC)
+
+	cases := []struct {
+		interval time.Duration
+		start    time.Time
+	}{
+		{10 * time.Second, time.Date(2019, 8, 1, 10, 17, 40, 0, time.UTC)},
+		{60 * time.Second, time.Date(2019, 8, 1, 10, 17, 0, 0, time.UTC)},
+		{5 * time.Minute, time.Date(2019, 8, 1, 10, 15, 0, 0, time.UTC)},
+		{time.Hour, time.Date(2019, 8, 1, 10, 0, 0, 0, time.UTC)},
+		{0, ts},
+	}
+
+	for _, c := range cases {
+		if got := alignTime(ts, c.interval); !got.Equal(c.start) {
+			t.Errorf("alignTime(%s) = %s, want %s", c.interval, got, c.start)
+		}
+	}
+
+	if got := nextBoundary(ts, 5*time.Minute); !got.Equal(time.Date(2019, 8, 1, 10, 20, 0, 0, time.UTC)) {
+		t.Errorf("nextBoundary = %s", got)
+	}
+}
+
+func TestNextMonitorAlignedTs(t *testing.T) {
+	s := NewStorage(3)
+	s.Interval = 10 * time.Second
+
+	s.NextMonitor()
+
+	if ts := s.NowMonitor.Ts; !ts.Equal(alignTime(ts, s.Interval)) || ts.Nanosecond() != 0 || ts.Second()%10 != 0 {
+		t.Fatalf("Ts %s not aligned to 10s", ts)
+	}
+}
+
+func TestStorageHistory(t *testing.T) {
+	s := NewStorage(3)
+	if s.History(1) != nil {
+		t.Fatalf("expect nil history before any rotation")
+	}
+
+	var rotated []*OneMinStorage
+	for i := 0; i < 5; i++ {
+		rotated = append(rotated, s.NextMonitor())
+	}
+	for n := 1; n <= 3; n++ {
+		if got := s.History(n); got != rotated[len(rotated)-n] {
+			t.Fatalf("History(%d) is not the %dth last rotated version", n, n)
+		}
+	}
+	for _, n := range []int{-1, 0, 4, 7} {
+		if s.History(n) != nil {
+			t.Fatalf("expect nil for History(%d)", n)
+		}
+	}
+
+	// 不保留历史版本时切换及查询都不出错
+	s = NewStorage(0)
+	s.NextMonitor()
+	s.NextMonitor()
+	if s.History(1) != nil {
+		t.Fatalf("expect nil history without revisions")
+	}
+}
@@ -0,0 +1,297 @@
+// Package monitortest 提供测试埋点代码用的内存 Writer, 手动切换周期及断言方法
+//
+// 使用方式:
+//
+//	m := monitortest.New(t)
+//	defer m.RecordFuncCount()()
+//	m.Rotate()
+//	m.AssertCount("pkg.Func", 1)
+package monitortest
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"monitor"
+
+	td "github.com/caio/go-tdigest"
+)
+
+// Metric 一个特殊指标在快照中的值
+type Metric struct {
+	Name     string            // 指标名
+	Tags     map[string]string // 指标的 tags
+	Describe string            // 描述信息
+	Type     int               // 指标类型
+	Sum      float64           // 总和
+	Count    int64             // 计数
+
+	digest *td.TDigest // 分位数, 非分位数类型为 nil
+}
+
+// Quantile 返回分位数, 非分位数类型返回 NaN
+func (m *Metric) Quantile(q float64) float64 {
+	if m.digest == nil {
+		return math.NaN()
+	}
+	return m.digest.Quantile(q)
+}
+
+// Snapshot 一个周期的数据快照
+type Snapshot struct {
+	Ts      time.Time          // 周期开始时间
+	Metrics map[string]*Metric // 特殊指标, key 为注册时的调用名
+	Data    map[string]float64 // 普通指标
+}
+
+// Recorder 内存 Writer, 记录每一次 DoWithRecover 的快照
+type Recorder struct {
+	sync.Mutex
+	t         testing.TB
+	snapshots []*Snapshot
+}
+
+// NewRecorder 返回一个内存 Writer, 断言失败时报告给 t
+func NewRecorder(t testing.TB) *Recorder {
+	return &Recorder{t: t}
+}
+
+// DoWithRecover 实现 monitor.Writer, 复制一份快照保存
+func (r *Recorder) DoWithRecover(nameMap *monitor.MetricNameMap, omd *monitor.OneMinStorage) error {
+	nameMap.RLock()
+	defer nameMap.RUnlock()
+	omd.RLock()
+	defer omd.RUnlock()
+
+	snap := &Snapshot{
+		Ts:      omd.Ts,
+		Metrics: make(map[string]*Metric, len(omd.PersistentData)),
+		Data:    make(map[string]float64, len(omd.Data)),
+	}
+
+	for key, id := range nameMap.CallNameMap {
+		name, ok := nameMap.Map[id]
+		sv, has := omd.PersistentData[id]
+		if !ok || !has {
+			continue
+		}
+
+		sv.RLock()
+		metric := &Metric{
+			Name:     name.Name,
+			Tags:     name.Tags,
+			Describe: name.Describe,
+			Type:     name.Type,
+			Sum:      sv.Sum,
+			Count:    sv.Count,
+		}
+		if sv.Otd != nil {
+			metric.digest = sv.Otd.Clone()
+			metric.Count = int64(sv.Otd.Count())
+		}
+		sv.RUnlock()
+
+		snap.Metrics[key] = metric
+	}
+
+	for name, value := range omd.Data {
+		snap.Data[name] = value
+	}
+
+	r.Lock()
+	r.snapshots = append(r.snapshots, snap)
+	r.Unlock()
+	return nil
+}
+
+// Snapshots 返回记录的所有快照
+func (r *Recorder) Snapshots() []*Snapshot {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*Snapshot(nil), r.snapshots...)
+}
+
+// Last 返回最后一个快照, 没有时返回 nil
+func (r *Recorder) Last() *Snapshot {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.snapshots) == 0 {
+		return nil
+	}
+	return r.snapshots[len(r.snapshots)-1]
+}
+
+// Reset 清空记录的快照
+func (r *Recorder) Reset() {
+	r.Lock()
+	r.snapshots = nil
+	r.Unlock()
+}
+
+// Monitor 测试用的 MONITOR, 使用 FakeClock 并挂载了 Recorder
+type Monitor struct {
+	*monitor.MONITOR
+	*Recorder
+	Clock *monitor.FakeClock
+}
+
+// New 返回一个测试用的 MONITOR, 不启动 HTTP 模块及后台循环
+// 通过 Rotate 手动切换周期
+func New(t testing.TB) *Monitor {
+	clock := monitor.NewFakeClock(time.Date(2019, 8, 1, 0, 0, 0, 0, time.UTC))
+
+	conf := monitor.NewConfig()
+	conf.Clock = clock
+
+	m, err := monitor.New(conf)
+	if err != nil {
+		t.Fatalf("monitortest: new monitor error %s", err)
+	}
+
+	rec := NewRecorder(t)
+	m.AddWriter("monitortest.Recorder", rec)
+
+	return &Monitor{MONITOR: m, Recorder: rec, Clock: clock}
+}
+
+// Rotate 将时钟推进一个周期并同步切换, 返回切换出来的周期的快照
+func (m *Monitor) Rotate() *Snapshot {
+	m.Clock.Advance(m.Conf.Interval)
+	m.Tick()
+	return m.Last()
+}
+
+// Rotate 同步切换任意 MONITOR 的周期, 数据交给其已配置的 Writer 处理
+func Rotate(m *monitor.MONITOR) *monitor.OneMinStorage {
+	return m.Tick()
+}
+
+// last 返回最后一个快照, 没有时报告错误
+func (r *Recorder) last() *Snapshot {
+	r.t.Helper()
+	snap := r.Last()
+	if snap == nil {
+		r.t.Errorf("monitortest: no snapshot recorded, forgot Rotate?")
+	}
+	return snap
+}
+
+// metric 在最后一个快照中查找特殊指标
+func (r *Recorder) metric(name string) *Metric {
+	r.t.Helper()
+	snap := r.last()
+	if snap == nil {
+		return nil
+	}
+	metric, ok := snap.Metrics[name]
+	if !ok {
+		r.t.Errorf("monitortest: metric %q not found", name)
+		return nil
+	}
+	return metric
+}
+
+// AssertCount 断言最后一个周期中指标的计数
+// 特殊指标比较 Count, 普通指标比较其值
+func (r *Recorder) AssertCount(name string, n int64) bool {
+	r.t.Helper()
+	snap := r.last()
+	if snap == nil {
+		return false
+	}
+
+	if metric, ok := snap.Metrics[name]; ok {
+		if metric.Count != n {
+			r.t.Errorf("monitortest: %s count = %d, want %d", name, metric.Count, n)
+			return false
+		}
+		return true
+	}
+
+	if value, ok := snap.Data[name]; ok {
+		if value != float64(n) {
+			r.t.Errorf("monitortest: %s count = %v, want %d", name, value, n)
+			return false
+		}
+		return true
+	}
+
+	r.t.Errorf("monitortest: metric %q not found", name)
+	return false
+}
+
+// AssertValue 断言最后一个周期中普通指标的值
+func (r *Recorder) AssertValue(name string, want float64) bool {
+	r.t.Helper()
+	snap := r.last()
+	if snap == nil {
+		return false
+	}
+
+	value, ok := snap.Data[name]
+	if !ok {
+		r.t.Errorf("monitortest: metric %q not found", name)
+		return false
+	}
+	if value != want {
+		r.t.Errorf("monitortest: %s = %v, want %v", name, value, want)
+		return false
+	}
+	return true
+}
+
+// AssertSum 断言最后一个周期中特殊指标的总和
+func (r *Recorder) AssertSum(name string, want float64) bool {
+	r.t.Helper()
+	metric := r.metric(name)
+	if metric == nil {
+		return false
+	}
+	if metric.Sum != want {
+		r.t.Errorf("monitortest: %s sum = %v, want %v", name, metric.Sum, want)
+		return false
+	}
+	return true
+}
+
+// AssertAvgBetween 断言最后一个周期中特殊指标的平均值在 [lo, hi] 之间
+func (r *Recorder) AssertAvgBetween(name string, lo, hi float64) bool {
+	r.t.Helper()
+	metric := r.metric(name)
+	if metric == nil {
+		return false
+	}
+	if metric.Count == 0 {
+		r.t.Errorf("monitortest: %s has no record", name)
+		return false
+	}
+
+	avg := metric.Sum / float64(metric.Count)
+	if avg < lo || avg > hi {
+		r.t.Errorf("monitortest: %s avg = %v, want in [%v, %v]", name, avg, lo, hi)
+		return false
+	}
+	return true
+}
+
+// AssertQuantile 断言最后一个周期中分位数指标的 q 分位值与 want 的误差不超过 delta
+func (r *Recorder) AssertQuantile(name string, q, want, delta float64) bool {
+	r.t.Helper()
+	metric := r.metric(name)
+	if metric == nil {
+		return false
+	}
+	if metric.digest == nil {
+		r.t.Errorf("monitortest: %s is not a quantile metric", name)
+		return false
+	}
+
+	got := metric.Quantile(q)
+	if math.Abs(got-want) > delta {
+		r.t.Errorf("monitortest: %s quantile(%v) = %v, want %v±%v", name, q, got, want, delta)
+		return false
+	}
+	return true
+}
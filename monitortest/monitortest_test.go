@@ -0,0 +1,78 @@
+package monitortest
+
+import (
+	"testing"
+	"time"
+
+	"monitor"
+)
+
+func TestRecorderAssertions(t *testing.T) {
+	m := New(t)
+
+	id, err := m.RegisterMetric("db.query", monitor.QuantileMetric, "query latency", nil)
+	if err != nil {
+		t.Fatalf("register metric error %s", err)
+	}
+	for i := 1; i <= 100; i++ {
+		m.AddPersistent(id, monitor.QuantileMetric, float64(i))
+	}
+
+	avg, _ := m.RegisterMetric("db.avg", monitor.AvgMetric, "", nil)
+	m.AddPersistent(avg, monitor.AvgMetric, 10)
+	m.AddPersistent(avg, monitor.AvgMetric, 20)
+
+	m.Add("biz.count", 3)
+	m.Set("queue.depth", 7)
+
+	snap := m.Rotate()
+	if want := time.Date(2019, 8, 1, 0, 0, 0, 0, time.UTC); !snap.Ts.Equal(want) {
+		t.Fatalf("snapshot Ts = %s, want %s", snap.Ts, want)
+	}
+
+	m.AssertCount("db.query", 100)
+	m.AssertQuantile("db.query", 0.5, 50, 2)
+	m.AssertCount("db.avg", 2)
+	m.AssertSum("db.avg", 30)
+	m.AssertAvgBetween("db.avg", 14, 16)
+	m.AssertCount("biz.count", 3)
+	m.AssertValue("queue.depth", 7)
+
+	// 下一个周期重新计数, Ts 推进一个周期
+	m.AddPersistent(avg, monitor.AvgMetric, 1)
+	snap = m.Rotate()
+	if want := time.Date(2019, 8, 1, 0, 1, 0, 0, time.UTC); !snap.Ts.Equal(want) {
+		t.Fatalf("snapshot Ts = %s, want %s", snap.Ts, want)
+	}
+	m.AssertCount("db.avg", 1)
+	if n := len(m.Snapshots()); n != 2 {
+		t.Fatalf("snapshots = %d, want 2", n)
+	}
+}
+
+// fakeTB 记录断言失败, 不让测试本身失败
+type fakeTB struct {
+	testing.TB
+	errors int
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...interface{}) {
+	f.errors++
+}
+
+func TestRecorderAssertFailure(t *testing.T) {
+	m := New(t)
+	m.Add("biz.count", 1)
+	m.Rotate()
+
+	ft := &fakeTB{TB: t}
+	m.Recorder.t = ft
+	if m.AssertCount("biz.count", 2) || m.AssertCount("missing", 1) {
+		t.Fatal("assertion should fail")
+	}
+	if ft.errors != 2 {
+		t.Fatalf("errors = %d, want 2", ft.errors)
+	}
+}
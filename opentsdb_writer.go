@@ -0,0 +1,209 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OpenTSDB 的 writer
+// tcp 方式发送 put <metric> <ts> <value> <k=v ...> 行, http 方式发送 /api/put 的 json
+// metric 为指标名加后缀, tags 为指标的 Tags 及 host
+
+// init 注册一个初始化 OpenTSDBWriter 的 Writer
+func init() {
+	f := func(conf *WriterConfig) Writer {
+		return &OpenTSDBWriter{
+			Conf:     conf,
+			Describe: "A OpenTSDB put writer",
+			client:   &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	RegisterWriterName["OpenTSDBWriter"] = f
+}
+
+const (
+	// OpenTSDBDefaultPath OpenTSDB http 写入的默认路径
+	OpenTSDBDefaultPath = "/api/put"
+	// openTSDBBatchSize 默认每批发送的数据点个数
+	openTSDBBatchSize = 50
+	// openTSDBBatchBytes 默认每批发送的最大字节数
+	openTSDBBatchBytes = 16 * 1024
+)
+
+// OpenTSDBWriter OpenTSDB 的 writer, 默认使用 tcp 协议
+type OpenTSDBWriter struct {
+	Conf     *WriterConfig
+	Describe string
+
+	client *http.Client
+}
+
+// openTSDBPoint /api/put 的数据点
+type openTSDBPoint struct {
+	Metric    string            `json:"metric"`
+	Timestamp int64             `json:"timestamp"`
+	Value     float64           `json:"value"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// DoWithRecover 处理一分钟的数据
+func (j *OpenTSDBWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			Logger.Error("writer panic at DoWithRecover", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyPanic, p)
+			err = &ErrWriterPanic{Name: j.Conf.Name, Panic: p}
+		}
+	}()
+
+	samples := splitSamples(pointsWithHost(writerPoints(j.Conf, nameMap, omd)))
+	msgs, err := j.encode(samples, omd.Ts)
+	if err == nil {
+		err = j.upload(msgs)
+	}
+	if err != nil {
+		Logger.Error("writer upload error", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+	}
+	return
+}
+
+// encode 按配置的协议将数据点分批编码, tcp 为 put 行, http 为 /api/put 的 json
+func (j *OpenTSDBWriter) encode(samples []sample, ts time.Time) ([][]byte, error) {
+	maxPoints, maxBytes := batchSize(j.Conf, openTSDBBatchSize), batchBytes(j.Conf, openTSDBBatchBytes)
+
+	switch j.Conf.Protocol {
+	case TCPProtocol, "":
+		size := func(s sample) int {
+			var buf bytes.Buffer
+			writeOpenTSDBLines(&buf, []sample{s}, ts)
+			return buf.Len()
+		}
+
+		var msgs [][]byte
+		for _, batch := range splitBatches(samples, maxPoints, maxBytes, size) {
+			var buf bytes.Buffer
+			writeOpenTSDBLines(&buf, batch, ts)
+			msgs = append(msgs, buf.Bytes())
+		}
+		return msgs, nil
+	case HTTPProtocol:
+		// json 数组的 [ ] 及数据点之间的 ,
+		size := func(s sample) int {
+			b, _ := json.Marshal(openTSDBPoints([]sample{s}, ts)[0])
+			return len(b) + 1
+		}
+
+		var msgs [][]byte
+		for _, batch := range splitBatches(samples, maxPoints, maxBytes-1, size) {
+			body, err := json.Marshal(openTSDBPoints(batch, ts))
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, body)
+		}
+		return msgs, nil
+	}
+
+	return nil, &ErrorWriterConfig{Msg: ProtocolError}
+}
+
+// upload 按配置的协议上传所有批次, 每批单独重试, 已上传的批次不会重复上传
+func (j *OpenTSDBWriter) upload(msgs [][]byte) error {
+	if j.Conf.Protocol != HTTPProtocol {
+		return uploadTCPBatches(j.Conf, msgs)
+	}
+
+	path := j.Conf.UploadPath
+	if path == "" {
+		path = OpenTSDBDefaultPath
+	}
+	url := "http://" + net.JoinHostPort(j.Conf.UpLoadHost, strconv.Itoa(j.Conf.UpLoadPort)) + path
+
+	for _, body := range msgs {
+		err := uploadWithRetry(j.Conf.UploadRetry, func() error {
+			resp, err := j.client.Post(url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode/100 != 2 {
+				return fmt.Errorf("opentsdb put status %s", resp.Status)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openTSDBMetric 返回数据点的 metric 名, 不支持的字符替换为 _
+func openTSDBMetric(s sample) string {
+	return sanitizeOpenTSDB(s.point.Name + s.point.Suffix[s.index])
+}
+
+// openTSDBTags 返回数据点的 tags, 空值的 tag OpenTSDB 不支持, 直接忽略
+func openTSDBTags(s sample) map[string]string {
+	tags := make(map[string]string, len(s.point.Tags))
+	for k, v := range s.point.Tags {
+		if k == "" || v == "" {
+			continue
+		}
+		tags[sanitizeOpenTSDB(k)] = sanitizeOpenTSDB(v)
+	}
+	return tags
+}
+
+// openTSDBPoints 将一批数据点转换为 /api/put 的 json 结构
+func openTSDBPoints(batch []sample, ts time.Time) []*openTSDBPoint {
+	points := make([]*openTSDBPoint, 0, len(batch))
+	for _, s := range batch {
+		points = append(points, &openTSDBPoint{
+			Metric:    openTSDBMetric(s),
+			Timestamp: ts.Unix(),
+			Value:     s.Value(),
+			Tags:      openTSDBTags(s),
+		})
+	}
+	return points
+}
+
+// writeOpenTSDBLines 将一批数据点格式化为 put 行
+func writeOpenTSDBLines(buf *bytes.Buffer, batch []sample, ts time.Time) {
+	tsStr := strconv.FormatInt(ts.Unix(), 10)
+
+	for _, s := range batch {
+		buf.WriteString("put ")
+		buf.WriteString(openTSDBMetric(s))
+		buf.WriteByte(' ')
+		buf.WriteString(tsStr)
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(s.Value(), 'g', -1, 64))
+
+		tags := openTSDBTags(s)
+		for _, k := range s.point.SortedTagKeys() {
+			k = sanitizeOpenTSDB(k)
+			if v, ok := tags[k]; ok {
+				buf.WriteByte(' ')
+				buf.WriteString(k)
+				buf.WriteByte(Equal)
+				buf.WriteString(v)
+			}
+		}
+		buf.WriteByte(NewLine)
+	}
+}
+
+// sanitizeOpenTSDB OpenTSDB 只支持 a-z A-Z 0-9 - _ . / 及 unicode 字母, 其余替换为 _
+func sanitizeOpenTSDB(s string) string {
+	return sanitize(s, func(r rune) bool {
+		return r == '-' || r == '_' || r == '.' || r == '/'
+	})
+}
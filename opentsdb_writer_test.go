@@ -0,0 +1,180 @@
+package monitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tcpCollector 本地 tcp 接收端, 收集一个连接的所有数据
+type tcpCollector struct {
+	ln   net.Listener
+	data chan []byte
+}
+
+func newTCPCollector(t *testing.T) *tcpCollector {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen tcp error %s", err)
+	}
+
+	c := &tcpCollector{ln: ln, data: make(chan []byte, 1)}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		c.data <- b
+	}()
+	return c
+}
+
+// config 返回指向该接收端的 writer 配置
+func (c *tcpCollector) config(name string) *WriterConfig {
+	conf := NewWriterConfig()
+	conf.ValidWriterName(name)
+	conf.ValidateMode(UP)
+	conf.UpLoadHost = "127.0.0.1"
+	conf.ValidateUpLoadPort(c.ln.Addr().(*net.TCPAddr).Port)
+	return conf
+}
+
+// received 返回收到的数据
+func (c *tcpCollector) received(t *testing.T) []byte {
+	defer c.ln.Close()
+	select {
+	case b := <-c.data:
+		return b
+	case <-time.After(2 * time.Second):
+		t.Fatal("no data received")
+	}
+	return nil
+}
+
+func TestOpenTSDBWriterTCP(t *testing.T) {
+	c := newTCPCollector(t)
+	w, _ := InitWriter(c.config("OpenTSDBWriter"))
+
+	if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+		t.Fatalf("opentsdb writer error %s", err)
+	}
+
+	got := string(c.received(t))
+	host := sanitizeOpenTSDB(HostName)
+	for _, line := range []string{
+		"put api_latency_Count 1564617600 2 host=" + host + " route=/users",
+		"put api_latency_Avg 1564617600 20 host=" + host + " route=/users",
+		"put biz.count 1564617600 3 host=" + host,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("data %q missing line %q", got, line)
+		}
+	}
+}
+
+func TestOpenTSDBWriterHTTPBatch(t *testing.T) {
+	var batches [][]*openTSDBPoint
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var points []*openTSDBPoint
+		json.NewDecoder(r.Body).Decode(&points)
+		batches = append(batches, points)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	conf := NewWriterConfig()
+	conf.ValidWriterName("OpenTSDBWriter")
+	conf.ValidateMode(UP)
+	conf.ValidateProtocol(HTTPProtocol)
+	conf.ValidateBatchSize(2)
+	conf.UpLoadHost = u.Hostname()
+	conf.ValidateUpLoadPort(port)
+
+	w, _ := InitWriter(conf)
+	if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+		t.Fatalf("opentsdb writer error %s", err)
+	}
+
+	// 3 个数据点, 每批 2 个
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("unexpected batches %v", batches)
+	}
+	if p := batches[0][0]; p.Timestamp != 1564617600 || p.Tags["host"] == "" {
+		t.Fatalf("unexpected point %+v", p)
+	}
+}
+
+func TestOpenTSDBWriterHTTPRetryBatch(t *testing.T) {
+	var requests int
+	var batches [][]*openTSDBPoint
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// 第二批第一次上传失败
+		if requests == 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var points []*openTSDBPoint
+		json.NewDecoder(r.Body).Decode(&points)
+		batches = append(batches, points)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	conf := NewWriterConfig()
+	conf.ValidWriterName("OpenTSDBWriter")
+	conf.ValidateMode(UP)
+	conf.ValidateProtocol(HTTPProtocol)
+	conf.ValidateBatchSize(2)
+	conf.UploadRetry = 1
+	conf.UpLoadHost = u.Hostname()
+	conf.ValidateUpLoadPort(port)
+
+	w, _ := InitWriter(conf)
+	if err := w.DoWithRecover(newTestStorage(t)); err != nil {
+		t.Fatalf("opentsdb writer error %s", err)
+	}
+
+	// 只重试失败的批次, 已上传的第一批不重复上传
+	if requests != 3 || len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("requests %d, unexpected batches %v", requests, batches)
+	}
+}
+
+func TestSplitBatchesBytes(t *testing.T) {
+	samples := splitSamples([]*Point{{Name: "a", Values: []float64{1, 2, 3, 4, 5}, Suffix: make([]string, 5)}})
+	size := func(s sample) int { return 10 }
+
+	var got []int
+	for _, batch := range splitBatches(samples, 100, 25, size) {
+		got = append(got, len(batch))
+	}
+	if want := []int{2, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("batches %v, want %v", got, want)
+	}
+
+	// 单个数据点超过限制时单独一批
+	got = got[:0]
+	for _, batch := range splitBatches(samples, 100, 5, size) {
+		got = append(got, len(batch))
+	}
+	if want := []int{1, 1, 1, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("batches %v, want %v", got, want)
+	}
+}
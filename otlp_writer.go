@@ -0,0 +1,378 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OpenTelemetry OTLP 的 writer, 通过 HTTP/protobuf 发送到 collector
+// CountMetric SumMetric BaseMetric 为 delta 的 Sum, AvgMetric 及普通指标为 Gauge
+// QuantileMetric 及 TimerMetric 为 Summary, HistogramMetric 为 delta 的 explicit-bucket Histogram
+// HostName 作为 resource 的 host.name 属性
+
+// init 注册一个初始化 OTLPWriter 的 Writer
+func init() {
+	f := func(conf *WriterConfig) Writer {
+		return &OTLPWriter{
+			Conf:     conf,
+			Describe: "A OTLP HTTP/protobuf metrics writer",
+			client:   &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+
+	RegisterWriterName["OTLPWriter"] = f
+}
+
+const (
+	// OTLPDefaultPath OTLP http 写入指标的默认路径
+	OTLPDefaultPath = "/v1/metrics"
+	// otlpScopeName instrumentation scope 的名字
+	otlpScopeName = "monitor"
+
+	// AggregationTemporality 的取值
+	otlpTemporalityDelta      = 1
+	otlpTemporalityCumulative = 2
+)
+
+// OTLPWriter OTLP 的 writer
+type OTLPWriter struct {
+	Conf     *WriterConfig
+	Describe string
+
+	client *http.Client
+}
+
+// DoWithRecover 处理一分钟的数据
+func (j *OTLPWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			Logger.Error("writer panic at DoWithRecover", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyPanic, p)
+			err = &ErrWriterPanic{Name: j.Conf.Name, Panic: p}
+		}
+	}()
+
+	end := omd.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	body := encodeOTLPMetrics(writerPoints(j.Conf, nameMap, omd), omd.Ts, end)
+
+	err = uploadWithRetry(j.Conf.UploadRetry, func() error {
+		return j.upload(body)
+	})
+	if err != nil {
+		Logger.Error("writer upload error", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+	}
+	return
+}
+
+// upload 通过 http 上传
+func (j *OTLPWriter) upload(body []byte) error {
+	path := j.Conf.UploadPath
+	if path == "" {
+		path = OTLPDefaultPath
+	}
+	addr := net.JoinHostPort(j.Conf.UpLoadHost, strconv.Itoa(j.Conf.UpLoadPort))
+
+	resp, err := j.client.Post("http://"+addr+path, "application/x-protobuf", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp export status %s", resp.Status)
+	}
+	return nil
+}
+
+// protobuf 的 wire type
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+// protoEncoder 简单的 protobuf 编码, 只实现 OTLP 用到的类型
+type protoEncoder struct {
+	bytes.Buffer
+}
+
+// key 写入字段号及 wire type
+func (e *protoEncoder) key(field int, wire int) {
+	e.varint(uint64(field)<<3 | uint64(wire))
+}
+
+// varint 写入 varint 编码的值
+func (e *protoEncoder) varint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	e.Write(buf[:n])
+}
+
+// uint 写入 varint 字段, 值为 0 时省略
+func (e *protoEncoder) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.key(field, protoVarint)
+	e.varint(v)
+}
+
+// fixed64 写入 fixed64 字段
+func (e *protoEncoder) fixed64(field int, v uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	e.key(field, protoFixed64)
+	e.Write(buf[:])
+}
+
+// double 写入 double 字段
+func (e *protoEncoder) double(field int, v float64) {
+	e.fixed64(field, math.Float64bits(v))
+}
+
+// string 写入 string 字段, 空字符串时省略
+func (e *protoEncoder) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.key(field, protoBytes)
+	e.varint(uint64(len(s)))
+	e.WriteString(s)
+}
+
+// message 写入嵌套的 message 字段
+func (e *protoEncoder) message(field int, f func(m *protoEncoder)) {
+	var m protoEncoder
+	f(&m)
+	e.key(field, protoBytes)
+	e.varint(uint64(m.Len()))
+	e.Write(m.Bytes())
+}
+
+// packedFixed64 写入 packed 的 repeated fixed64 字段, 为空时省略
+func (e *protoEncoder) packedFixed64(field int, vs []uint64) {
+	if len(vs) == 0 {
+		return
+	}
+	e.key(field, protoBytes)
+	e.varint(uint64(len(vs) * 8))
+
+	var buf [8]byte
+	for _, v := range vs {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		e.Write(buf[:])
+	}
+}
+
+// keyValue 写入 KeyValue 字段, value 为字符串
+func (e *protoEncoder) keyValue(field int, k, v string) {
+	e.message(field, func(kv *protoEncoder) {
+		kv.string(1, k)
+		kv.message(2, func(any *protoEncoder) {
+			any.string(1, v)
+		})
+	})
+}
+
+// otlpPoint 数据点的公共字段
+type otlpPoint struct {
+	tags       map[string]string
+	tagKeys    []string
+	start, end uint64
+}
+
+// attributes 写入数据点的属性, 字段号由调用方给出
+func (o *otlpPoint) attributes(e *protoEncoder, field int) {
+	for _, k := range o.tagKeys {
+		e.keyValue(field, k, o.tags[k])
+	}
+}
+
+// encodeOTLPMetrics 将 Point 列表编码为 ExportMetricsServiceRequest
+func encodeOTLPMetrics(points []*Point, start, end time.Time) []byte {
+	var req protoEncoder
+
+	// ResourceMetrics
+	req.message(1, func(rm *protoEncoder) {
+		// Resource
+		rm.message(1, func(res *protoEncoder) {
+			res.keyValue(1, "host.name", HostName)
+		})
+		// ScopeMetrics
+		rm.message(2, func(sm *protoEncoder) {
+			sm.message(1, func(scope *protoEncoder) {
+				scope.string(1, otlpScopeName)
+			})
+			for _, p := range points {
+				op := &otlpPoint{
+					tags:    p.Tags,
+					tagKeys: p.SortedTagKeys(),
+					start:   uint64(start.UnixNano()),
+					end:     uint64(end.UnixNano()),
+				}
+				encodeOTLPPoint(sm, p, op)
+			}
+		})
+	})
+
+	return req.Bytes()
+}
+
+// encodeOTLPPoint 按指标类型将一个 Point 编码为一个或多个 Metric
+func encodeOTLPPoint(sm *protoEncoder, p *Point, op *otlpPoint) {
+	if p.Plain {
+		encodeOTLPGauge(sm, p.Name, p.Describe, op, p.Values[0])
+		return
+	}
+
+	// 累计值输出为 cumulative Sum, 开始时间为指标注册的时间, 重启后开始时间变化表示重置
+	for i, value := range p.Values {
+		if !p.IsTotal(i) {
+			continue
+		}
+		total := *op
+		total.start = uint64(p.Start.UnixNano())
+		encodeOTLPSum(sm, p.Name+p.Suffix[i], p.Describe, &total, value, p.FieldName(i) == "CountTotal", otlpTemporalityCumulative)
+	}
+
+	if p.Type == QuantileMetric || p.Type == TimerMetric {
+		encodeOTLPSummary(sm, p, op)
+		return
+	}
+	if p.Type == HistogramMetric {
+		encodeOTLPHistogram(sm, p, op)
+		return
+	}
+
+	for i, value := range p.Values {
+		if p.IsTotal(i) {
+			continue
+		}
+		name := p.Name + p.Suffix[i]
+		switch p.FieldName(i) {
+		case "Count":
+			encodeOTLPSum(sm, name, p.Describe, op, value, true, otlpTemporalityDelta)
+		case "Sum":
+			encodeOTLPSum(sm, name, p.Describe, op, value, false, otlpTemporalityDelta)
+		default:
+			encodeOTLPGauge(sm, name, p.Describe, op, value)
+		}
+	}
+}
+
+// numberDataPoint 写入 NumberDataPoint
+func numberDataPoint(e *protoEncoder, op *otlpPoint, value float64) {
+	e.message(1, func(dp *protoEncoder) {
+		dp.fixed64(2, op.start)
+		dp.fixed64(3, op.end)
+		dp.double(4, value)
+		op.attributes(dp, 7)
+	})
+}
+
+// encodeOTLPGauge 写入 Gauge 类型的 Metric
+func encodeOTLPGauge(sm *protoEncoder, name, desc string, op *otlpPoint, value float64) {
+	sm.message(2, func(m *protoEncoder) {
+		m.string(1, name)
+		m.string(2, desc)
+		m.message(5, func(g *protoEncoder) {
+			numberDataPoint(g, op, value)
+		})
+	})
+}
+
+// encodeOTLPSum 写入 Sum 类型的 Metric, temporality 为 delta 或 cumulative
+func encodeOTLPSum(sm *protoEncoder, name, desc string, op *otlpPoint, value float64, monotonic bool, temporality uint64) {
+	sm.message(2, func(m *protoEncoder) {
+		m.string(1, name)
+		m.string(2, desc)
+		m.message(7, func(s *protoEncoder) {
+			numberDataPoint(s, op, value)
+			s.uint(2, temporality)
+			if monotonic {
+				s.uint(3, 1)
+			}
+		})
+	})
+}
+
+// encodeOTLPSummary 写入 Summary 类型的 Metric
+func encodeOTLPSummary(sm *protoEncoder, p *Point, op *otlpPoint) {
+	sm.message(2, func(m *protoEncoder) {
+		m.string(1, p.Name)
+		m.string(2, p.Describe)
+		m.string(3, p.Unit)
+		m.message(11, func(s *protoEncoder) {
+			s.message(1, func(dp *protoEncoder) {
+				dp.fixed64(2, op.start)
+				dp.fixed64(3, op.end)
+				dp.fixed64(4, uint64(p.Count))
+				dp.double(5, p.Sum)
+				// 按 OTLP 的约定, 分位点 0 和 1 分别为最小值和最大值
+				quantiles := append([]float64{0}, p.Quantiles...)
+				values := append([]float64{math.NaN()}, p.Values[:len(p.Quantiles)]...)
+				if min, ok := p.Field("Min"); ok {
+					values[0] = min
+				}
+				if max, ok := p.Field("Max"); ok {
+					quantiles, values = append(quantiles, 1), append(values, max)
+				}
+
+				for i, q := range quantiles {
+					value := values[i]
+					if math.IsNaN(value) {
+						continue
+					}
+					dp.message(6, func(vq *protoEncoder) {
+						vq.double(1, q)
+						vq.double(2, value)
+					})
+				}
+				op.attributes(dp, 7)
+			})
+		})
+	})
+}
+
+// encodeOTLPHistogram 写入 delta 的 explicit-bucket Histogram 类型的 Metric
+// OTLP 的 bucket_counts 为各桶自身的计数, 由累计计数还原
+func encodeOTLPHistogram(sm *protoEncoder, p *Point, op *otlpPoint) {
+	counts := make([]uint64, len(p.Buckets)+1)
+	bounds := make([]uint64, len(p.Buckets))
+	var prev float64
+	for i := range counts {
+		counts[i] = uint64(p.Values[i] - prev)
+		prev = p.Values[i]
+	}
+	for i, b := range p.Buckets {
+		bounds[i] = math.Float64bits(b)
+	}
+
+	sm.message(2, func(m *protoEncoder) {
+		m.string(1, p.Name)
+		m.string(2, p.Describe)
+		m.message(9, func(h *protoEncoder) {
+			h.message(1, func(dp *protoEncoder) {
+				dp.fixed64(2, op.start)
+				dp.fixed64(3, op.end)
+				dp.fixed64(4, uint64(p.Count))
+				dp.double(5, p.Sum)
+				dp.packedFixed64(6, counts)
+				dp.packedFixed64(7, bounds)
+				op.attributes(dp, 9)
+			})
+			h.uint(2, otlpTemporalityDelta)
+		})
+	})
+}
@@ -0,0 +1,137 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// protoField 解码出的一个 protobuf 字段
+type protoField struct {
+	v uint64 // varint 及 fixed64 的值
+	b []byte // length-delimited 的值
+}
+
+// decodeProto 解码一层 protobuf message, 测试中的 OTLP 接收端使用
+func decodeProto(t *testing.T, b []byte) map[int][]protoField {
+	fields := make(map[int][]protoField)
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+
+		var f protoField
+		switch key & 7 {
+		case protoVarint:
+			f.v, n = binary.Uvarint(b)
+			b = b[n:]
+		case protoFixed64:
+			f.v = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case protoBytes:
+			l, n := binary.Uvarint(b)
+			f.b = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields[int(key>>3)] = append(fields[int(key>>3)], f)
+	}
+	return fields
+}
+
+func TestOTLPWriter(t *testing.T) {
+	var body []byte
+	var contentType, path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		contentType, path = r.Header.Get("Content-Type"), r.URL.Path
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	conf := NewWriterConfig()
+	conf.ValidWriterName("OTLPWriter")
+	conf.ValidateMode(UP)
+	conf.UpLoadHost = u.Hostname()
+	conf.ValidateUpLoadPort(port)
+
+	m, _ := New(NewConfig())
+	cid, _ := m.RegisterMetric("rpc.calls", CountAvgMetric, "rpc calls", map[string]string{"route": "/users"})
+	m.AddPersistent(cid, CountAvgMetric, 10)
+	m.AddPersistent(cid, CountAvgMetric, 30)
+	qid, _ := m.RegisterMetric("rpc.latency", QuantileMetric, "rpc latency", nil)
+	for i := 1; i <= 100; i++ {
+		m.AddPersistent(qid, QuantileMetric, float64(i))
+	}
+	m.Set("queue.depth", 5)
+	omd := m.Core.NextMonitor()
+	omd.Ts, omd.End = time.Unix(1564617600, 0), time.Unix(1564617660, 0)
+
+	w, _ := InitWriter(conf)
+	if err := w.DoWithRecover(m.Core.MetricMap, omd); err != nil {
+		t.Fatalf("otlp writer error %s", err)
+	}
+
+	if contentType != "application/x-protobuf" || path != OTLPDefaultPath {
+		t.Fatalf("content type %q, path %q", contentType, path)
+	}
+
+	rm := decodeProto(t, decodeProto(t, body)[1][0].b)
+
+	// resource 的 host.name
+	attr := decodeProto(t, decodeProto(t, rm[1][0].b)[1][0].b)
+	if string(attr[1][0].b) != "host.name" || string(decodeProto(t, attr[2][0].b)[1][0].b) != HostName {
+		t.Fatalf("unexpected resource attribute %v", attr)
+	}
+
+	// 按名字索引 Metric, 值为 数据类型的字段号 -> message
+	metrics := make(map[string]map[int][]protoField)
+	for _, mf := range decodeProto(t, rm[2][0].b)[2] {
+		metric := decodeProto(t, mf.b)
+		metrics[string(metric[1][0].b)] = metric
+	}
+
+	// Count 为 monotonic 的 delta Sum
+	sum := decodeProto(t, metrics["rpc.calls_Count"][7][0].b)
+	if sum[2][0].v != otlpTemporalityDelta || sum[3][0].v != 1 {
+		t.Fatalf("rpc.calls_Count not a monotonic delta sum: %v", sum)
+	}
+	dp := decodeProto(t, sum[1][0].b)
+	if dp[2][0].v != uint64(omd.Ts.UnixNano()) || dp[3][0].v != uint64(omd.End.UnixNano()) ||
+		math.Float64frombits(dp[4][0].v) != 2 {
+		t.Fatalf("unexpected data point %v", dp)
+	}
+	if string(decodeProto(t, dp[7][0].b)[1][0].b) != "route" {
+		t.Fatalf("data point attribute missing")
+	}
+	if string(metrics["rpc.calls_Count"][2][0].b) != "rpc calls" {
+		t.Fatalf("description missing")
+	}
+
+	// Avg 及普通指标为 Gauge
+	for _, name := range []string{"rpc.calls_Avg", "queue.depth"} {
+		if _, ok := metrics[name][5]; !ok {
+			t.Fatalf("%s not a gauge", name)
+		}
+	}
+
+	// 分位数为 Summary
+	sdp := decodeProto(t, decodeProto(t, metrics["rpc.latency"][11][0].b)[1][0].b)
+	if sdp[4][0].v != 100 || math.Float64frombits(sdp[5][0].v) != 5050 || len(sdp[6]) != 6 {
+		t.Fatalf("unexpected summary %v", sdp)
+	}
+	// 分位点 0 和 1 为最小值和最大值
+	first, last := decodeProto(t, sdp[6][0].b), decodeProto(t, sdp[6][5].b)
+	if math.Float64frombits(first[1][0].v) != 0 || math.Float64frombits(first[2][0].v) != 1 ||
+		math.Float64frombits(last[1][0].v) != 1 || math.Float64frombits(last[2][0].v) != 100 {
+		t.Fatalf("unexpected min max quantile %v %v", first, last)
+	}
+}
@@ -1,3 +1,69 @@
 package monitor
 
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
 // 用于上传的 writer, 通过简单的文本及 tcp 方式发送
+// 文本为 Graphite 的 plaintext 格式, 每行一个数据点: 路径 值 时间戳
+
+// init 注册一个初始化 PlainUploadWriter 的 Writer 及 graphite 格式
+func init() {
+	f := func(conf *WriterConfig) Writer {
+		return &PlainUploadWriter{
+			Conf:     conf,
+			Describe: "A Graphite plaintext over tcp writer",
+		}
+	}
+
+	RegisterWriterName["PlainUploadWriter"] = f
+	RegisterFormatterName[GraphiteFormat] = func(conf *WriterConfig) Formatter {
+		return &GraphiteFormatter{}
+	}
+}
+
+// PlainUploadWriter Graphite plaintext 的 writer, 默认通过 tcp 发送
+type PlainUploadWriter struct {
+	Conf     *WriterConfig
+	Describe string
+}
+
+// DoWithRecover 处理一分钟的数据
+func (j *PlainUploadWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			Logger.Error("writer panic at DoWithRecover", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyPanic, p)
+			err = &ErrWriterPanic{Name: j.Conf.Name, Panic: p}
+		}
+	}()
+
+	return writeFormatted(j.Conf, nameMap, omd, GraphiteFormat, TCPProtocol)
+}
+
+// GraphiteFormatter Graphite 的 plaintext 格式, 路径与 GraphitePickleWriter 相同
+type GraphiteFormatter struct{}
+
+// ContentType 实现 Formatter 接口
+func (f *GraphiteFormatter) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+// Format 实现 Formatter 接口
+func (f *GraphiteFormatter) Format(w io.Writer, snap *Snapshot) error {
+	var buf bytes.Buffer
+	ts := strconv.FormatInt(snap.Ts.Unix(), 10)
+
+	for _, s := range splitSamples(pointsWithHost(snap.Points)) {
+		buf.WriteString(graphitePath(s))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(s.Value(), 'g', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(ts)
+		buf.WriteByte(NewLine)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
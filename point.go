@@ -0,0 +1,267 @@
+package monitor
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// 一个周期内的数据整理为 Point 列表, 供各 Writer 格式化使用
+
+// Point 一个指标在一个周期内的输出值
+type Point struct {
+	Name     string            // 指标名
+	Tags     map[string]string // tag的名称和 tag 值的映射
+	Describe string            // 描述信息
+	Type     int               // 指标类型, 普通指标为 BaseMetric
+	Plain    bool              // 是否为 Add/Set 记录的普通指标, 普通指标没有后缀
+	Suffix   []string          // 后缀, 与 Values 一一对应
+	Values   []float64         // 值
+
+	Sum       float64   // 特殊指标的原始总和
+	Count     int64     // 特殊指标的原始计数
+	Quantiles []float64 // 分位数类型的分位点, 与 Values 的前 len(Quantiles) 个一一对应
+	Buckets   []float64 // 直方图类型的桶上界, 与 Values 的前 len(Buckets) 个累计计数一一对应, 之后一个为 +Inf
+	Unit      string    // 单位, 耗时类型为 WithTimeUnit 配置的单位, 默认为 ms, 其余为空
+	Start     time.Time // 累计值的开始时间, 未配置 WithCumulative 时为零值, 变化时表示累计值已重置
+
+	// TotalBuckets 配置了 WithCumulative 的直方图自 Start 以来各桶的累计计数, 与 Buckets 对应, 最后一个为 +Inf
+	TotalBuckets []float64
+}
+
+// IsTotal 返回第 i 个值是否为自 Start 以来的累计值
+func (p *Point) IsTotal(i int) bool {
+	return !p.Start.IsZero() && strings.HasSuffix(p.FieldName(i), "Total")
+}
+
+// FieldName 返回第 i 个值的字段名, 即去掉下划线的后缀, 普通指标为 value
+func (p *Point) FieldName(i int) string {
+	if p.Plain {
+		return "value"
+	}
+	return strings.TrimPrefix(p.Suffix[i], "_")
+}
+
+// Field 返回字段名为 name 的值
+func (p *Point) Field(name string) (float64, bool) {
+	for i := range p.Values {
+		if p.FieldName(i) == name {
+			return p.Values[i], true
+		}
+	}
+	return 0, false
+}
+
+// SortedTagKeys 返回排过序的 tag key
+func (p *Point) SortedTagKeys() []string {
+	keys := make([]string, 0, len(p.Tags))
+	for k := range p.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sample Point 中的单个值, 用于按数据点输出的 Writer
+type sample struct {
+	point *Point
+	index int
+}
+
+// Value 返回数据点的值
+func (s sample) Value() float64 {
+	return s.point.Values[s.index]
+}
+
+// splitSamples 将 Point 列表展开为数据点, NaN 和 Inf 的值忽略
+func splitSamples(points []*Point) []sample {
+	samples := make([]sample, 0, len(points))
+	for _, p := range points {
+		for i, value := range p.Values {
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			samples = append(samples, sample{point: p, index: i})
+		}
+	}
+	return samples
+}
+
+// splitBatches 将数据点切分为批次, 每批最多 maxPoints 个, 编码后最多 maxBytes 字节
+// size 返回一个数据点编码后的字节数, 单个数据点超过 maxBytes 时单独一批
+func splitBatches(samples []sample, maxPoints, maxBytes int, size func(sample) int) [][]sample {
+	var batches [][]sample
+	start, bytes := 0, 0
+	for i, s := range samples {
+		n := size(s)
+		if i > start && (i-start >= maxPoints || bytes+n > maxBytes) {
+			batches = append(batches, samples[start:i])
+			start, bytes = i, 0
+		}
+		bytes += n
+	}
+	if start < len(samples) {
+		batches = append(batches, samples[start:])
+	}
+	return batches
+}
+
+// pointsWithHost 返回添加了 host tag 的 Point 列表, 已有 host tag 的保持不变
+func pointsWithHost(points []*Point) []*Point {
+	ret := make([]*Point, 0, len(points))
+	for _, p := range points {
+		if _, ok := p.Tags["host"]; ok {
+			ret = append(ret, p)
+			continue
+		}
+
+		np := *p
+		np.Tags = make(map[string]string, len(p.Tags)+1)
+		for k, v := range p.Tags {
+			np.Tags[k] = v
+		}
+		np.Tags["host"] = HostName
+		ret = append(ret, &np)
+	}
+	return ret
+}
+
+// collectPoints 将一个周期的数据整理为 Point 列表
+func collectPoints(nameMap *MetricNameMap, omd *OneMinStorage) []*Point {
+	nameMap.RLock()
+	defer nameMap.RUnlock()
+	omd.RLock()
+	defer omd.RUnlock()
+
+	points := make([]*Point, 0, omd.Len())
+
+	for id, SPV := range omd.PersistentData {
+		metric, ok := nameMap.Map[id]
+		if !ok {
+			continue
+		}
+
+		SPV.RLock()
+		if metric.Type == TopKMetric {
+			points = append(points, topKPoints(metric, SPV)...)
+			SPV.RUnlock()
+			continue
+		}
+		p := &Point{
+			Name:     metric.Name,
+			Tags:     metric.Tags,
+			Describe: metric.Describe,
+			Type:     metric.Type,
+			Suffix:   metric.Suffixes(),
+			Values:   getValues(metric, SPV),
+			Sum:      SPV.Sum,
+			Count:    SPV.Count,
+		}
+		if metric.Type == HistogramMetric && metric.Cumulative {
+			p.TotalBuckets = histogramTotals(SPV)
+		}
+		SPV.RUnlock()
+
+		switch metric.Type {
+		case QuantileMetric:
+			p.Quantiles = metric.quantiles()
+		case TimerMetric:
+			p.Quantiles = metric.quantiles()
+			_, p.Unit = metric.timeUnit()
+		case HistogramMetric:
+			p.Buckets = metric.buckets()
+		}
+		if metric.Cumulative {
+			p.Start = metric.Created
+		}
+		points = append(points, p)
+	}
+
+	for name, value := range omd.Data {
+		points = append(points, &Point{
+			Name:   name,
+			Type:   BaseMetric,
+			Plain:  true,
+			Suffix: []string{""},
+			Values: []float64{value},
+		})
+	}
+
+	sortPoints(points)
+	return points
+}
+
+// writerPoints 返回按 writer 配置的精度取整后的 Point 列表
+func writerPoints(conf *WriterConfig, nameMap *MetricNameMap, omd *OneMinStorage) []*Point {
+	points := collectPoints(nameMap, omd)
+	roundPoints(points, conf.Precision)
+	return points
+}
+
+// sortPoints 按指标名排序, 同名的按 tags 排序, 特殊指标在普通指标之前, 保证每个周期输出的顺序一致
+func sortPoints(points []*Point) {
+	keys := make(map[*Point]string, len(points))
+	for _, p := range points {
+		keys[p] = p.tagsKey()
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		a, b := points[i], points[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if keys[a] != keys[b] {
+			return keys[a] < keys[b]
+		}
+		return !a.Plain && b.Plain
+	})
+}
+
+// tagsKey 返回排序用的 tags 字符串, 格式为 k1=v1;k2=v2
+func (p *Point) tagsKey() string {
+	var buf strings.Builder
+	for i, k := range p.SortedTagKeys() {
+		if i > 0 {
+			buf.WriteByte(Semicolon)
+		}
+		buf.WriteString(k)
+		buf.WriteByte(Equal)
+		buf.WriteString(p.Tags[k])
+	}
+	return buf.String()
+}
+
+// roundPoints 将值按 precision 位小数四舍五入, precision 为 FullPrecision 时不处理
+func roundPoints(points []*Point, precision int) {
+	if precision < 0 {
+		return
+	}
+	for _, p := range points {
+		for i, value := range p.Values {
+			p.Values[i] = roundFloat(value, precision)
+		}
+		p.Sum = roundFloat(p.Sum, precision)
+	}
+}
+
+// roundFloat 将 value 按 precision 位小数四舍五入, NaN Inf 及溢出时返回原值
+func roundFloat(value float64, precision int) float64 {
+	pow := math.Pow10(precision)
+	scaled := value * pow
+	if math.IsNaN(scaled) || math.IsInf(scaled, 0) {
+		return value
+	}
+	return math.Round(scaled) / pow
+}
+
+// sanitize 保留字母, 数字及 allow 允许的字符, 其余替换为 _
+func sanitize(s string, allow func(r rune) bool) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || allow(r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,198 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Prometheus 文本格式 (text/plain; version=0.0.4)
+// 直方图类型输出为 histogram, 配置了 WithCumulative 时各桶 总和及次数为自 Start 以来的累计值, 单调递增
+// 未配置时为该周期内的增量
+// 分位数类型的分位点输出为带 quantile label 的 gauge, 总和及次数输出为 指标名_sum 指标名_count gauge
+// 其余每个后缀输出为一个 gauge, 指标名为 指标名后缀
+// 每个周期的值为该周期内的增量, 因此使用 gauge 类型; 配置了 WithCumulative 的累计值输出为 counter
+
+// init 注册 prometheus 格式
+func init() {
+	RegisterFormatterName[PrometheusFormat] = func(conf *WriterConfig) Formatter {
+		return &PrometheusFormatter{}
+	}
+}
+
+// PrometheusFormatter Prometheus 文本格式
+type PrometheusFormatter struct{}
+
+// promFamily 同名指标的集合, HELP 和 TYPE 只输出一次
+type promFamily struct {
+	name    string
+	help    string
+	typ     string
+	samples bytes.Buffer
+}
+
+// prometheus 中需要转义的字符
+var (
+	promHelpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	promLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// ContentType 实现 Formatter 接口
+func (f *PrometheusFormatter) ContentType() string {
+	return "text/plain; version=0.0.4; charset=utf-8"
+}
+
+// Format 实现 Formatter 接口
+func (f *PrometheusFormatter) Format(w io.Writer, snap *Snapshot) error {
+	families := make(map[string]*promFamily)
+	order := make([]*promFamily, 0, len(snap.Points))
+
+	family := func(name, help, typ string) *promFamily {
+		if fm, ok := families[name]; ok {
+			return fm
+		}
+		fm := &promFamily{name: name, help: help, typ: typ}
+		families[name] = fm
+		order = append(order, fm)
+		return fm
+	}
+
+	for _, p := range snap.Points {
+		if p.Plain {
+			name := promName(p.Name)
+			writePromSample(&family(name, p.Describe, "gauge").samples, name, p, promLabel{}, p.Values[0])
+			continue
+		}
+
+		if len(p.Buckets) > 0 {
+			writePromHistogram(family(promName(p.Name), p.Describe, "histogram"), p)
+			continue
+		}
+
+		// 累计值在各类型中都在最后, 输出为 counter
+		for i := range p.Values {
+			if p.IsTotal(i) {
+				name := promName(p.Name + p.Suffix[i])
+				writePromSample(&family(name, p.Describe, "counter").samples, name, p, promLabel{}, p.Values[i])
+			}
+		}
+
+		if len(p.Quantiles) > 0 {
+			name := promName(p.Name)
+			fm := family(name, p.Describe, "gauge")
+			for i, q := range p.Quantiles {
+				writePromSample(&fm.samples, name, p, promLabel{"quantile", strconv.FormatFloat(q, 'g', -1, 64)}, p.Values[i])
+			}
+			writePromSumCount(family, name, p)
+
+			// 分位点之后的 Count Sum 已输出为 _count _sum, 最小值 最大值输出为 gauge
+			for i := len(p.Quantiles); i < len(p.Values); i++ {
+				if field := p.FieldName(i); field == "Count" || field == "Sum" || p.IsTotal(i) {
+					continue
+				}
+				gauge := promName(p.Name + p.Suffix[i])
+				writePromSample(&family(gauge, p.Describe, "gauge").samples, gauge, p, promLabel{}, p.Values[i])
+			}
+			continue
+		}
+
+		for i, value := range p.Values {
+			if p.IsTotal(i) {
+				continue
+			}
+			name := promName(p.Name + p.Suffix[i])
+			writePromSample(&family(name, p.Describe, "gauge").samples, name, p, promLabel{}, value)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, fm := range order {
+		if fm.help != "" {
+			fmt.Fprintf(&buf, "# HELP %s %s\n", fm.name, promHelpEscaper.Replace(fm.help))
+		}
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", fm.name, fm.typ)
+		buf.Write(fm.samples.Bytes())
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writePromHistogram 输出直方图的 _bucket _sum _count
+// 配置了 WithCumulative 时使用自 Start 以来的累计值, 否则为该周期内的增量
+func writePromHistogram(fm *promFamily, p *Point) {
+	buckets, sum, count := p.Values[:len(p.Buckets)+1], p.Sum, float64(p.Count)
+	if p.TotalBuckets != nil {
+		buckets = p.TotalBuckets
+		sum, _ = p.Field("SumTotal")
+		count, _ = p.Field("CountTotal")
+	}
+
+	for i, bound := range p.Buckets {
+		writePromSample(&fm.samples, fm.name+"_bucket", p, promLabel{"le", strconv.FormatFloat(bound, 'g', -1, 64)}, buckets[i])
+	}
+	writePromSample(&fm.samples, fm.name+"_bucket", p, promLabel{"le", "+Inf"}, buckets[len(p.Buckets)])
+	writePromSample(&fm.samples, fm.name+"_sum", p, promLabel{}, sum)
+	writePromSample(&fm.samples, fm.name+"_count", p, promLabel{}, count)
+}
+
+// writePromSumCount 输出分位数类型的 指标名_sum 指标名_count gauge
+func writePromSumCount(family func(name, help, typ string) *promFamily, name string, p *Point) {
+	sum := family(name+"_sum", p.Describe, "gauge")
+	writePromSample(&sum.samples, sum.name, p, promLabel{}, p.Sum)
+	count := family(name+"_count", p.Describe, "gauge")
+	writePromSample(&count.samples, count.name, p, promLabel{}, float64(p.Count))
+}
+
+// promLabel 附加的 label, 如分位点的 quantile 及直方图的 le
+type promLabel struct {
+	name, value string
+}
+
+// writePromSample 写入一个数据点, extra 的 name 不为空时添加该 label
+func writePromSample(buf *bytes.Buffer, name string, p *Point, extra promLabel, value float64) {
+	buf.WriteString(name)
+
+	labels := make([]string, 0, len(p.Tags)+1)
+	for _, k := range p.SortedTagKeys() {
+		labels = append(labels, promLabelName(k)+`="`+promLabelEscaper.Replace(p.Tags[k])+`"`)
+	}
+	if extra.name != "" {
+		labels = append(labels, extra.name+`="`+extra.value+`"`)
+	}
+	if len(labels) > 0 {
+		buf.WriteByte('{')
+		buf.WriteString(strings.Join(labels, ","))
+		buf.WriteByte('}')
+	}
+
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	buf.WriteByte(NewLine)
+}
+
+// promName 指标名只保留 [a-zA-Z0-9_:], 不能以数字开头
+func promName(s string) string {
+	return promIdentifier(s, true)
+}
+
+// promLabelName label 名只保留 [a-zA-Z0-9_], 不能以数字开头
+func promLabelName(s string) string {
+	return promIdentifier(s, false)
+}
+
+// promIdentifier 将不合法的字符替换为 _, colon 为 true 时保留 :
+func promIdentifier(s string, colon bool) string {
+	s = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || (colon && r == ':') {
+			return r
+		}
+		return '_'
+	}, s)
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		s = "_" + s
+	}
+	return s
+}
@@ -0,0 +1,39 @@
+package monitor
+
+import (
+	"math"
+)
+
+// 速率指标, 每个周期记录事件数 (Add 的值之和), 周期切换时除以周期的实际时长得到每秒速率
+// 周期的时长为前后两个版本 Ts 之差, 第一个周期从启动开始, 不一定是完整的周期
+// 同时计算 1/5/15 个周期的指数加权移动平均, 与 Unix 的 load average 类似
+
+// rateWindows EWMA 的窗口, 单位为周期数, 与 RateSuffix 的 _Rate1 _Rate5 _Rate15 一一对应
+var rateWindows = [3]float64{1, 5, 15}
+
+// newRateSpecValue 返回速率类型的特殊数据, 速率及 EWMA 在计算前为 NaN
+func newRateSpecValue() *SpecValue {
+	nan := math.NaN()
+	return &SpecValue{
+		Rate: nan,
+		EWMA: [3]float64{nan, nan, nan},
+	}
+}
+
+// finishRate 按周期的时长 seconds 计算速率并更新 EWMA, 时长不为正时不处理
+// 第一次计算时 EWMA 直接取本周期的速率
+func (s *SpecValue) finishRate(seconds float64) {
+	if !(seconds > 0) {
+		return
+	}
+
+	s.Rate = s.Sum / seconds
+	for i, window := range rateWindows {
+		if math.IsNaN(s.EWMA[i]) {
+			s.EWMA[i] = s.Rate
+			continue
+		}
+		alpha := 1 - math.Exp(-1/window)
+		s.EWMA[i] += alpha * (s.Rate - s.EWMA[i])
+	}
+}
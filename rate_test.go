@@ -0,0 +1,64 @@
+package monitor
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateMetric(t *testing.T) {
+	clock := NewFakeClock(time.Date(2019, 8, 1, 10, 0, 30, 0, time.UTC))
+	conf := NewConfig()
+	conf.Clock = clock
+	m, _ := New(conf)
+
+	id, err := m.RegisterMetric("requests", RateMetric, "requests per second", nil)
+	if err != nil {
+		t.Fatalf("register error %s", err)
+	}
+	values := func(omd *OneMinStorage) []float64 {
+		return getValues(m.Core.MetricMap.Map[id], omd.PersistentData[id])
+	}
+
+	// 当前周期尚未计算速率
+	for _, v := range values(m.Core.NowMonitor) {
+		if !math.IsNaN(v) {
+			t.Fatalf("expect NaN before rotation, got %v", values(m.Core.NowMonitor))
+		}
+	}
+
+	// 第一个周期从启动开始, 只有 30 秒
+	for i := 0; i < 60; i++ {
+		m.AddPersistent(id, RateMetric, 1)
+	}
+	clock.Advance(30 * time.Second)
+	got := values(m.Core.NextMonitor())
+	if got[0] != 2 || got[1] != 2 || got[2] != 2 || got[3] != 2 {
+		t.Fatalf("first interval rate %v, want 2", got)
+	}
+
+	// 完整的 60 秒周期, EWMA 按窗口平滑
+	m.AddPersistent(id, RateMetric, 300)
+	clock.Advance(60 * time.Second)
+	got = values(m.Core.NextMonitor())
+	if got[0] != 5 {
+		t.Fatalf("second interval rate %v, want 5", got[0])
+	}
+	for i, window := range rateWindows {
+		want := 2 + (1-math.Exp(-1/window))*3
+		if math.Abs(got[i+1]-want) > 1e-9 {
+			t.Fatalf("ewma %v = %v, want %v", window, got[i+1], want)
+		}
+	}
+	if !(got[1] > got[2] && got[2] > got[3]) {
+		t.Fatalf("shorter window should react faster, got %v", got)
+	}
+
+	// 没有事件的周期速率为 0, EWMA 衰减
+	prev := got
+	clock.Advance(60 * time.Second)
+	got = values(m.Core.NextMonitor())
+	if got[0] != 0 || !(got[1] < prev[1] && got[3] < prev[3]) {
+		t.Fatalf("idle interval rate %v, previous %v", got, prev)
+	}
+}
@@ -0,0 +1,275 @@
+package monitor
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 追加写入的本地文件, 按大小或时间轮转
+// 轮转后的文件命名为 名字-开始时间.序号.扩展名.gz, 只保留最新的 MaxBackups 个
+
+const (
+	// rotateTimeFormat 轮转文件名中的时间格式, 按字典序即时间序
+	rotateTimeFormat = "20060102T150405"
+	// rotateSeqFormat 轮转文件名中同一秒内的序号, 补零使字典序即轮转的顺序
+	rotateSeqFormat = "%06d"
+)
+
+// rotateFile 按大小或时间轮转的追加写入文件
+type rotateFile struct {
+	sync.Mutex
+	path        string        // 当前写入的文件
+	maxSize     int64         // 单个文件的最大字节数, 0 为不按大小轮转
+	rotateEvery time.Duration // 按时间轮转的周期, 0 为不按时间轮转
+	maxBackups  int           // 保留的压缩文件个数, 0 为全部保留
+	perm        os.FileMode   // 文件权限
+
+	file     *os.File
+	size     int64
+	openedAt time.Time // 当前文件第一次写入的数据的时间
+}
+
+// newRotateFile 根据 writer 的配置返回一个轮转文件
+func newRotateFile(conf *WriterConfig) *rotateFile {
+	return &rotateFile{
+		path:        conf.DownPath,
+		maxSize:     conf.MaxFileSize,
+		rotateEvery: conf.RotateInterval,
+		maxBackups:  conf.MaxBackups,
+		perm:        fileMode(conf),
+	}
+}
+
+// Write 写入一个周期的数据, ts 为该周期的时间
+// header 不为空时在新文件的开头写入, 如 csv 的表头
+func (r *rotateFile) Write(data []byte, header []byte, ts time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file != nil && r.needRotate(int64(len(data)), ts) {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if r.file == nil {
+		if err := r.open(ts); err != nil {
+			return err
+		}
+	}
+
+	if r.size == 0 && len(header) > 0 {
+		n, err := r.file.Write(header)
+		r.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := r.file.Write(data)
+	r.size += int64(n)
+	return err
+}
+
+// Close 关闭当前文件
+func (r *rotateFile) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// needRotate 判断写入 n 个字节前是否需要轮转
+func (r *rotateFile) needRotate(n int64, ts time.Time) bool {
+	if r.maxSize > 0 && r.size > 0 && r.size+n > r.maxSize {
+		return true
+	}
+	if r.rotateEvery > 0 && !alignTime(ts, r.rotateEvery).Equal(alignTime(r.openedAt, r.rotateEvery)) {
+		return true
+	}
+	return false
+}
+
+// open 以追加方式打开当前文件, 已存在的文件继续写入
+func (r *rotateFile) open(ts time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(r.path), defaultDirMode); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, r.perm)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file, r.size, r.openedAt = f, info.Size(), ts
+	if r.size > 0 {
+		r.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+// rotate 关闭当前文件, 压缩为带时间的文件名, 并清理多余的轮转文件
+// 压缩失败时保留未压缩的轮转文件, 同样参与清理
+func (r *rotateFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	archive := r.archiveName(r.openedAt)
+	if err := os.Rename(r.path, archive); err != nil {
+		return err
+	}
+	gzErr := gzipFile(archive, r.perm)
+
+	if err := r.prune(); err != nil {
+		return err
+	}
+	return gzErr
+}
+
+// archiveName 返回轮转后文件的名字, 序号为同一秒内已有文件的最大序号加 1
+// 不复用已被清理的序号, 保证字典序即轮转的顺序
+func (r *rotateFile) archiveName(ts time.Time) string {
+	ts = ts.UTC().Truncate(time.Second)
+
+	seq := 0
+	files, _ := r.backups()
+	for _, f := range files {
+		fts, n, _ := r.parseArchive(f)
+		if fts.Equal(ts) && n >= seq {
+			seq = n + 1
+		}
+	}
+
+	ext := filepath.Ext(r.path)
+	return strings.TrimSuffix(r.path, ext) + "-" + ts.Format(rotateTimeFormat) + "." + fmt.Sprintf(rotateSeqFormat, seq) + ext
+}
+
+// parseArchive 解析轮转文件名中的时间和序号
+// 只接受 名字-开始时间.序号.扩展名 及其 .gz, 不匹配其它 writer 以相同前缀命名的文件
+func (r *rotateFile) parseArchive(path string) (ts time.Time, seq int, ok bool) {
+	ext := filepath.Ext(r.path)
+	prefix := strings.TrimSuffix(r.path, ext) + "-"
+	name := strings.TrimSuffix(path, ".gz")
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+		return
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+
+	// 时间和序号之间以 . 分隔, 序号只含数字
+	i := strings.IndexByte(name, '.')
+	if i != len(rotateTimeFormat) || len(name) == i+1 || strings.Trim(name[i+1:], "0123456789") != "" {
+		return
+	}
+	ts, err := time.Parse(rotateTimeFormat, name[:i])
+	if err != nil {
+		return
+	}
+	seq, err = strconv.Atoi(name[i+1:])
+	return ts, seq, err == nil
+}
+
+// backups 返回所有轮转文件, 包括压缩失败未压缩的, 按时间和序号从旧到新排序
+func (r *rotateFile) backups() ([]string, error) {
+	ext := filepath.Ext(r.path)
+	files, err := filepath.Glob(strings.TrimSuffix(r.path, ext) + "-*" + ext + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	type archive struct {
+		path string
+		ts   time.Time
+		seq  int
+	}
+	archives := make([]archive, 0, len(files))
+	for _, f := range files {
+		if ts, seq, ok := r.parseArchive(f); ok {
+			archives = append(archives, archive{path: f, ts: ts, seq: seq})
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		if !archives[i].ts.Equal(archives[j].ts) {
+			return archives[i].ts.Before(archives[j].ts)
+		}
+		return archives[i].seq < archives[j].seq
+	})
+
+	files = files[:0]
+	for _, a := range archives {
+		files = append(files, a.path)
+	}
+	return files, nil
+}
+
+// prune 只保留最新的 maxBackups 个轮转文件
+func (r *rotateFile) prune() error {
+	if r.maxBackups <= 0 {
+		return nil
+	}
+
+	files, err := r.backups()
+	if err != nil {
+		return err
+	}
+	for len(files) > r.maxBackups {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// gzipFile 将文件压缩为 .gz 并删除原文件, 失败时保留原文件
+func gzipFile(path string, perm os.FileMode) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(dst.Name())
+		}
+	}()
+
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err != nil {
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+
+	src.Close()
+	return os.Remove(path)
+}
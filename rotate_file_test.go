@@ -0,0 +1,151 @@
+package monitor
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func readGzip(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s error %s", path, err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip reader error %s", err)
+	}
+	b, _ := ioutil.ReadAll(zr)
+	return string(b)
+}
+
+func TestRotateFileBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "monitor.csv")
+	conf := NewWriterConfig()
+	conf.DownPath = path
+	conf.ValidateRotate(10, 0, 2)
+
+	r := newRotateFile(conf)
+	defer r.Close()
+
+	ts := time.Date(2019, 8, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		if err := r.Write([]byte("12345678\n"), []byte("h\n"), ts.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("write error %s", err)
+		}
+	}
+
+	backups, _ := r.backups()
+	if len(backups) != 2 {
+		t.Fatalf("backups = %v, want 2", backups)
+	}
+	// 最旧的一个已被清理
+	if want := filepath.Join(filepath.Dir(path), "monitor-20190801T100100.000000.csv.gz"); backups[0] != want {
+		t.Fatalf("oldest backup = %s, want %s", backups[0], want)
+	}
+	if got := readGzip(t, backups[1]); got != "h\n12345678\n" {
+		t.Fatalf("backup content %q", got)
+	}
+
+	b, _ := ioutil.ReadFile(path)
+	if string(b) != "h\n12345678\n" {
+		t.Fatalf("current content %q", b)
+	}
+}
+
+func TestRotateFileByTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitor.jsonl")
+	conf := NewWriterConfig()
+	conf.DownPath = path
+	conf.ValidateRotate(0, time.Hour, 0)
+
+	r := newRotateFile(conf)
+	defer r.Close()
+
+	ts := time.Date(2019, 8, 1, 10, 58, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		r.Write([]byte("line\n"), nil, ts.Add(time.Duration(i)*time.Minute))
+	}
+
+	backups, _ := r.backups()
+	if len(backups) != 1 || readGzip(t, backups[0]) != "line\nline\n" {
+		t.Fatalf("unexpected backups %v", backups)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "line\n" {
+		t.Fatalf("current content %q", b)
+	}
+}
+
+func TestRotateFileSameSecond(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitor.csv")
+	conf := NewWriterConfig()
+	conf.DownPath = path
+	conf.ValidateRotate(4, 0, 2)
+
+	r := newRotateFile(conf)
+	defer r.Close()
+
+	// 同一秒内轮转多次, 序号补零, 清理时保留最新的
+	ts := time.Date(2019, 8, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 12; i++ {
+		if err := r.Write([]byte{byte('a' + i), '\n', '\n', '\n'}, nil, ts); err != nil {
+			t.Fatalf("write error %s", err)
+		}
+	}
+
+	backups, _ := r.backups()
+	want := []string{
+		filepath.Join(filepath.Dir(path), "monitor-20190801T100000.000009.csv.gz"),
+		filepath.Join(filepath.Dir(path), "monitor-20190801T100000.000010.csv.gz"),
+	}
+	if len(backups) != 2 || backups[0] != want[0] || backups[1] != want[1] {
+		t.Fatalf("backups = %v, want %v", backups, want)
+	}
+	if got := readGzip(t, backups[1]); got != "k\n\n\n" {
+		t.Fatalf("newest backup content %q", got)
+	}
+}
+
+func TestRotateFileBackupsName(t *testing.T) {
+	dir := t.TempDir()
+	conf := NewWriterConfig()
+	conf.DownPath = filepath.Join(dir, "app.jsonl")
+	conf.ValidateRotate(0, 0, 2)
+	r := newRotateFile(conf)
+
+	// 其它 writer 的轮转文件及不符合命名的文件不参与清理
+	// 压缩失败留下的未压缩轮转文件参与清理
+	for _, name := range []string{
+		"app-errors.jsonl", "app-errors-20190801T100000.000000.jsonl.gz", "app-20190801T100000.x.jsonl.gz",
+		"app-20190801T100000.000001.jsonl", "app-20190801T100000.000000.jsonl.gz", "app-20190801T090000.000005.jsonl.gz",
+	} {
+		ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+
+	backups, _ := r.backups()
+	want := []string{
+		filepath.Join(dir, "app-20190801T090000.000005.jsonl.gz"),
+		filepath.Join(dir, "app-20190801T100000.000000.jsonl.gz"),
+		filepath.Join(dir, "app-20190801T100000.000001.jsonl"),
+	}
+	if !reflect.DeepEqual(backups, want) {
+		t.Fatalf("backups = %v, want %v", backups, want)
+	}
+	if got := r.archiveName(time.Date(2019, 8, 1, 10, 0, 0, 0, time.UTC)); got != filepath.Join(dir, "app-20190801T100000.000002.jsonl") {
+		t.Fatalf("archive name %s", got)
+	}
+
+	r.prune()
+	if _, err := os.Stat(want[0]); !os.IsNotExist(err) {
+		t.Fatalf("oldest backup not pruned")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app-errors-20190801T100000.000000.jsonl.gz")); err != nil {
+		t.Fatalf("other writer backup removed %s", err)
+	}
+}
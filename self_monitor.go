@@ -0,0 +1,87 @@
+package monitor
+
+import (
+	"net/http"
+)
+
+// 监控自身状态的指标, 统一放在 SelfMonitorKey 的命名空间下
+// 以 Data 普通指标的方式记录, 随每个周期一起落地 or 上传, 便于对监控本身报警
+// Writer 的耗时 失败 及 panic 在写完一个周期后才能得到, 记录在下一个周期中, 即晚一个周期输出
+
+const (
+	// SelfSeriesNum 当前周期的业务指标序列数, 包括特殊指标和普通指标, 不包括自身监控指标
+	SelfSeriesNum = SelfMonitorKey + ".SeriesNum"
+	// SelfDroppedRecords 因指标 ID 不存在而丢弃的记录调用次数
+	SelfDroppedRecords = SelfMonitorKey + ".DroppedRecords"
+	// SelfWriterPanics Writer 中 recover 住的 panic 次数, 为上一个周期写入时的次数
+	SelfWriterPanics = SelfMonitorKey + ".WriterPanics"
+	// SelfWriterFailures Writer 返回错误的次数, 为上一个周期写入时的次数
+	SelfWriterFailures = SelfMonitorKey + ".WriterFailures"
+	// SelfWriterDuration Writer 的耗时(ms) 前缀, 后接 Writer 名, 为上一个周期写入的耗时
+	SelfWriterDuration = SelfMonitorKey + ".WriterDurationMs."
+	// SelfNextMonitorDuration NextMonitor 切换版本的耗时(ms)
+	SelfNextMonitorDuration = SelfMonitorKey + ".NextMonitorDurationMs"
+	// SelfHTTPRequests HTTP 模块处理的请求数
+	SelfHTTPRequests = SelfMonitorKey + ".HTTPRequests"
+	// SelfLoopPanics 后台周期切换中 recover 住的 panic 次数
+	SelfLoopPanics = SelfMonitorKey + ".LoopPanics"
+	// SelfRecordPanics 记录方法中 recover 住的 panic 次数
+	SelfRecordPanics = SelfMonitorKey + ".RecordPanics"
+)
+
+// addSelf 在当前周期中累加一个自身监控指标
+func (m *MONITOR) addSelf(key string, value float64) {
+	m.Core.RLock()
+	defer m.Core.RUnlock()
+	m.Core.NowMonitor.Add(key, value)
+}
+
+// setSelf 在当前周期中设置一个自身监控指标
+func (m *MONITOR) setSelf(key string, value float64) {
+	m.Core.RLock()
+	defer m.Core.RUnlock()
+	m.Core.NowMonitor.Set(key, value)
+}
+
+// doWrite 执行一个 Writer 并记录其耗时, 失败 及 panic 次数
+// omd 写入时已在格式化输出, 统计记录在当前周期中, 随下一个周期输出
+// Writer 自身未 recover 的 panic 在这里兜底
+func (m *MONITOR) doWrite(name string, writer Writer, omd *OneMinStorage) {
+	defer func() {
+		if p := recover(); p != nil {
+			m.addSelf(SelfWriterPanics, 1)
+			m.addSelf(SelfWriterFailures, 1)
+			Logger.Error("writer panic", LogKeyWriter, name, LogKeyTs, omd.Ts.Unix(), LogKeyPanic, p)
+		}
+	}()
+
+	start := m.Core.Clock.Now()
+	err := writer.DoWithRecover(m.Core.MetricMap, omd)
+	m.setSelf(SelfWriterDuration+name, m.Core.Clock.Now().Sub(start).Seconds()*1000)
+
+	if err == nil {
+		return
+	}
+
+	if _, ok := err.(*ErrWriterPanic); ok {
+		m.addSelf(SelfWriterPanics, 1)
+	}
+	m.addSelf(SelfWriterFailures, 1)
+}
+
+// recoverRecord 记录方法的 recover, 需直接 defer 调用
+// 记录方法中的 panic 转换为自身监控指标及日志, 不影响业务
+func (m *MONITOR) recoverRecord(method string) {
+	if p := recover(); p != nil {
+		m.addSelf(SelfRecordPanics, 1)
+		Logger.Error("record method panic", "method", method, LogKeyPanic, p)
+	}
+}
+
+// selfHTTPMiddleware 记录 HTTP 模块处理的请求数
+func (m *MONITOR) selfHTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.addSelf(SelfHTTPRequests, 1)
+		next.ServeHTTP(w, r)
+	})
+}
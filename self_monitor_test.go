@@ -0,0 +1,79 @@
+package monitor
+
+import "testing"
+
+func TestSelfMonitorDroppedAndSeries(t *testing.T) {
+	s := NewStorage(3)
+
+	s.NowMonitor.AddPersistent(404, AvgMetric, 1)
+	s.NowMonitor.SetPersistent(404, AvgMetric, 1)
+	s.NowMonitor.Add("biz.count", 1)
+
+	now := s.NextMonitor()
+
+	if v, _ := now.Get(SelfDroppedRecords); v != 2 {
+		t.Fatalf("dropped records = %v, want 2", v)
+	}
+	// 只统计业务指标 biz.count, 不包括 DroppedRecords 等自身监控指标
+	if v, _ := now.Get(SelfSeriesNum); v != 1 {
+		t.Fatalf("series num = %v, want 1", v)
+	}
+	if _, err := now.Get(SelfNextMonitorDuration); err != nil {
+		t.Fatalf("next monitor duration not recorded: %s", err)
+	}
+}
+
+type panicWriter struct{}
+
+func (p *panicWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &ErrWriterPanic{Name: "panicWriter", Panic: r}
+		}
+	}()
+	panic("boom")
+}
+
+func TestSelfMonitorWriterPanic(t *testing.T) {
+	m, _ := New(NewConfig())
+	m.doWrite("panicWriter", &panicWriter{}, m.Core.NextMonitor())
+
+	// 写入完成时 omd 已输出, 统计记录在当前周期中
+	if v, _ := m.Core.NowMonitor.Get(SelfWriterPanics); v != 1 {
+		t.Fatalf("writer panics = %v, want 1", v)
+	}
+	if v, _ := m.Core.NowMonitor.Get(SelfWriterFailures); v != 1 {
+		t.Fatalf("writer failures = %v, want 1", v)
+	}
+	if _, err := m.Core.NowMonitor.Get(SelfWriterDuration + "panicWriter"); err != nil {
+		t.Fatalf("writer duration not recorded: %s", err)
+	}
+}
+
+func TestRecordPanicIsolated(t *testing.T) {
+	m, _ := New(NewConfig())
+	id, _ := m.initNewMetricName("avg.metric", AvgMetric, "", map[string]string{})
+
+	// AvgMetric 的 SpecValue 没有 Otd, 按分位数记录会 panic
+	m.AddPersistent(id, QuantileMetric, 1)
+	// 锁应已释放, 之后的记录不受影响
+	m.AddPersistent(id, AvgMetric, 3)
+
+	if v, _ := m.Core.NowMonitor.Get(SelfRecordPanics); v != 1 {
+		t.Fatalf("record panics = %v, want 1", v)
+	}
+	if sv := m.Core.NowMonitor.GetPersistent(id); sv.Sum != 3 || sv.Count != 1 {
+		t.Fatalf("unexpected spec value %s", sv)
+	}
+}
+
+func TestRotatePanicIsolated(t *testing.T) {
+	m, _ := New(NewConfig())
+	m.Core.HistoryMonitor = nil
+
+	m.rotate()
+
+	if v, _ := m.Core.NowMonitor.Get(SelfLoopPanics); v != 1 {
+		t.Fatalf("loop panics = %v, want 1", v)
+	}
+}
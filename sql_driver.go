@@ -0,0 +1,429 @@
+package monitor
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+)
+
+// database/sql 的 driver 包装, 不修改调用方即可记录各操作的耗时及错误
+// 按 db op outcome 记录 SQLOperations 耗时指标, op 为 prepare query exec begin commit rollback
+// driver.ErrSkip 表示 driver 不支持该方法, database/sql 会改用其它方式执行, 不记录
+// 连接池的统计通过 RegisterDBStats 在每次切换版本前记录为 gauge
+
+// SQL 指标名
+const (
+	SQLOperations = "sql.client.operations" // 各操作的耗时, TimerMetric
+
+	SQLMaxOpen      = "sql.db.max_open"         // 最大连接数
+	SQLOpen         = "sql.db.open"             // 已建立的连接数
+	SQLInUse        = "sql.db.in_use"           // 使用中的连接数
+	SQLIdle         = "sql.db.idle"             // 空闲的连接数
+	SQLWaitCount    = "sql.db.wait_count"       // 等待连接的累计次数
+	SQLWaitDuration = "sql.db.wait_duration_ms" // 等待连接的累计耗时, 单位 ms
+)
+
+// SQL 操作
+const (
+	sqlOpPrepare  = "prepare"
+	sqlOpQuery    = "query"
+	sqlOpExec     = "exec"
+	sqlOpBegin    = "begin"
+	sqlOpCommit   = "commit"
+	sqlOpRollback = "rollback"
+)
+
+// errSQLNamedParams 不支持 NamedValue 的 driver 使用了命名参数, 与 database/sql 的错误一致
+var errSQLNamedParams = errors.New("sql: driver does not support the use of Named Parameters")
+
+// sqlSeries SQL 指标序列的缓存 key
+type sqlSeries struct {
+	op, outcome string
+}
+
+// sqlRecorder 记录一个 db 的操作, 同一 driver 的连接共享
+type sqlRecorder struct {
+	sync.RWMutex                   // 保护 ids
+	m            *MONITOR          // 记录指标的 MONITOR
+	db           string            // 指标的 db tag
+	ids          map[sqlSeries]int // 已注册的序列
+}
+
+// record 记录从 start 开始的一次操作, err 为 driver.ErrSkip 时不记录
+func (r *sqlRecorder) record(op string, start time.Time, err error) {
+	if err == driver.ErrSkip {
+		return
+	}
+	elapsed := time.Since(start)
+
+	s := sqlSeries{op: op, outcome: Outcome(err)}
+	r.RLock()
+	id, ok := r.ids[s]
+	r.RUnlock()
+	if !ok {
+		var rerr error
+		id, rerr = r.m.RegisterMetric(SQLOperations, TimerMetric, "sql "+SQLOperations,
+			map[string]string{"db": r.db, "op": s.op, OutcomeTag: s.outcome})
+		if rerr != nil {
+			Logger.Error("register sql metric error", LogKeyMetric, SQLOperations, LogKeyErr, rerr)
+			return
+		}
+		r.Lock()
+		r.ids[s] = id
+		r.Unlock()
+	}
+
+	r.m.AddPersistent(id, TimerMetric, float64(elapsed)/float64(DefaultTimeUnit))
+}
+
+// RegisterDriver 以 driverName 注册包装了 d 的 driver, db 为指标的 db tag
+// 与 sql.Register 相同, driverName 重复时 panic
+func (m *MONITOR) RegisterDriver(driverName, db string, d driver.Driver) {
+	sql.Register(driverName, m.WrapDriver(db, d))
+}
+
+// WrapDriver 返回记录指标的 driver, db 为指标的 db tag
+// d 实现了 driver.DriverContext 时, 返回的 driver 同样实现
+func (m *MONITOR) WrapDriver(db string, d driver.Driver) driver.Driver {
+	w := &sqlDriver{parent: d, rec: &sqlRecorder{m: m, db: db, ids: make(map[sqlSeries]int)}}
+	if _, ok := d.(driver.DriverContext); ok {
+		return &sqlDriverContext{w}
+	}
+	return w
+}
+
+// WrapConnector 返回记录指标的 Connector, 用于 sql.OpenDB
+func (m *MONITOR) WrapConnector(db string, c driver.Connector) driver.Connector {
+	w := &sqlDriver{parent: c.Driver(), rec: &sqlRecorder{m: m, db: db, ids: make(map[sqlSeries]int)}}
+	return &sqlConnector{parent: c, driver: w}
+}
+
+// sqlDriver 包装的 driver
+type sqlDriver struct {
+	parent driver.Driver
+	rec    *sqlRecorder
+}
+
+// Open 实现 driver.Driver
+func (d *sqlDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.parent.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{parent: conn, rec: d.rec}, nil
+}
+
+// sqlDriverContext 包装实现了 driver.DriverContext 的 driver
+type sqlDriverContext struct {
+	*sqlDriver
+}
+
+// OpenConnector 实现 driver.DriverContext
+func (d *sqlDriverContext) OpenConnector(name string) (driver.Connector, error) {
+	c, err := d.parent.(driver.DriverContext).OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConnector{parent: c, driver: d}, nil
+}
+
+// sqlConnector 包装的 Connector
+type sqlConnector struct {
+	parent driver.Connector
+	driver driver.Driver
+}
+
+// Connect 实现 driver.Connector
+func (c *sqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.parent.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{parent: conn, rec: c.recorder()}, nil
+}
+
+// Driver 实现 driver.Connector
+func (c *sqlConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// recorder 返回 driver 的 sqlRecorder
+func (c *sqlConnector) recorder() *sqlRecorder {
+	if d, ok := c.driver.(*sqlDriverContext); ok {
+		return d.rec
+	}
+	return c.driver.(*sqlDriver).rec
+}
+
+// sqlConn 包装的连接, 实现 database/sql 使用的可选接口, 底层未实现时返回 driver.ErrSkip 或默认行为
+type sqlConn struct {
+	parent driver.Conn
+	rec    *sqlRecorder
+}
+
+// Prepare 实现 driver.Conn
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext 实现 driver.ConnPrepareContext
+func (c *sqlConn) PrepareContext(ctx context.Context, query string) (stmt driver.Stmt, err error) {
+	start := time.Now()
+	if pc, ok := c.parent.(driver.ConnPrepareContext); ok {
+		stmt, err = pc.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.parent.Prepare(query)
+	}
+	c.rec.record(sqlOpPrepare, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStmt{parent: stmt, conn: c}, nil
+}
+
+// Close 实现 driver.Conn
+func (c *sqlConn) Close() error {
+	return c.parent.Close()
+}
+
+// Begin 实现 driver.Conn
+func (c *sqlConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx 实现 driver.ConnBeginTx, 底层不支持时与 database/sql 相同, 只支持默认的选项
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (tx driver.Tx, err error) {
+	start := time.Now()
+	if bc, ok := c.parent.(driver.ConnBeginTx); ok {
+		tx, err = bc.BeginTx(ctx, opts)
+	} else if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		err = errors.New("sql: driver does not support non-default isolation level")
+	} else if opts.ReadOnly {
+		err = errors.New("sql: driver does not support read-only transactions")
+	} else {
+		tx, err = c.parent.Begin()
+	}
+	c.rec.record(sqlOpBegin, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{parent: tx, rec: c.rec}, nil
+}
+
+// ExecContext 实现 driver.ExecerContext
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (res driver.Result, err error) {
+	start := time.Now()
+	switch e := c.parent.(type) {
+	case driver.ExecerContext:
+		res, err = e.ExecContext(ctx, query, args)
+	case driver.Execer:
+		var values []driver.Value
+		if values, err = namedValueToValue(args); err == nil {
+			res, err = e.Exec(query, values)
+		}
+	default:
+		return nil, driver.ErrSkip
+	}
+	c.rec.record(sqlOpExec, start, err)
+	return
+}
+
+// QueryContext 实现 driver.QueryerContext
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+	start := time.Now()
+	switch q := c.parent.(type) {
+	case driver.QueryerContext:
+		rows, err = q.QueryContext(ctx, query, args)
+	case driver.Queryer:
+		var values []driver.Value
+		if values, err = namedValueToValue(args); err == nil {
+			rows, err = q.Query(query, values)
+		}
+	default:
+		return nil, driver.ErrSkip
+	}
+	c.rec.record(sqlOpQuery, start, err)
+	return
+}
+
+// Ping 实现 driver.Pinger, 底层不支持时返回 nil
+func (c *sqlConn) Ping(ctx context.Context) error {
+	if p, ok := c.parent.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession 实现 driver.SessionResetter, 底层不支持时返回 nil
+func (c *sqlConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.parent.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+// IsValid 实现 driver.Validator, 底层不支持时返回 true
+func (c *sqlConn) IsValid() bool {
+	if v, ok := c.parent.(interface{ IsValid() bool }); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue 实现 driver.NamedValueChecker, 底层不支持时返回 driver.ErrSkip 使用默认的转换
+func (c *sqlConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.parent.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// sqlStmt 包装的预编译语句
+type sqlStmt struct {
+	parent driver.Stmt
+	conn   *sqlConn
+}
+
+// Close 实现 driver.Stmt
+func (s *sqlStmt) Close() error {
+	return s.parent.Close()
+}
+
+// NumInput 实现 driver.Stmt
+func (s *sqlStmt) NumInput() int {
+	return s.parent.NumInput()
+}
+
+// Exec 实现 driver.Stmt
+func (s *sqlStmt) Exec(args []driver.Value) (driver.Result, error) {
+	start := time.Now()
+	res, err := s.parent.Exec(args)
+	s.conn.rec.record(sqlOpExec, start, err)
+	return res, err
+}
+
+// Query 实现 driver.Stmt
+func (s *sqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	start := time.Now()
+	rows, err := s.parent.Query(args)
+	s.conn.rec.record(sqlOpQuery, start, err)
+	return rows, err
+}
+
+// ExecContext 实现 driver.StmtExecContext
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (res driver.Result, err error) {
+	start := time.Now()
+	if e, ok := s.parent.(driver.StmtExecContext); ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValueToValue(args); err == nil {
+			res, err = s.parent.Exec(values)
+		}
+	}
+	s.conn.rec.record(sqlOpExec, start, err)
+	return
+}
+
+// QueryContext 实现 driver.StmtQueryContext
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+	start := time.Now()
+	if q, ok := s.parent.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValueToValue(args); err == nil {
+			rows, err = s.parent.Query(values)
+		}
+	}
+	s.conn.rec.record(sqlOpQuery, start, err)
+	return
+}
+
+// CheckNamedValue 实现 driver.NamedValueChecker, 依次使用语句及连接的实现, 都不支持时返回 driver.ErrSkip
+func (s *sqlStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := s.parent.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+// ColumnConverter 实现 driver.ColumnConverter, 底层不支持时使用 driver.DefaultParameterConverter
+func (s *sqlStmt) ColumnConverter(idx int) driver.ValueConverter {
+	if cc, ok := s.parent.(driver.ColumnConverter); ok {
+		return cc.ColumnConverter(idx)
+	}
+	return driver.DefaultParameterConverter
+}
+
+// sqlTx 包装的事务
+type sqlTx struct {
+	parent driver.Tx
+	rec    *sqlRecorder
+}
+
+// Commit 实现 driver.Tx
+func (t *sqlTx) Commit() error {
+	start := time.Now()
+	err := t.parent.Commit()
+	t.rec.record(sqlOpCommit, start, err)
+	return err
+}
+
+// Rollback 实现 driver.Tx
+func (t *sqlTx) Rollback() error {
+	start := time.Now()
+	err := t.parent.Rollback()
+	t.rec.record(sqlOpRollback, start, err)
+	return err
+}
+
+// namedValueToValue 将 NamedValue 转为 Value, 不支持命名参数
+func namedValueToValue(named []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(named))
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, errSQLNamedParams
+		}
+		values[i] = nv.Value
+	}
+	return values, nil
+}
+
+// RegisterDBStats 在每次切换版本前将 db 连接池的统计记录为 gauge, tags 为 db
+// 同一 name 重复注册时替换之前的 db, db 关闭后调用 UnregisterDBStats 停止采集
+func (m *MONITOR) RegisterDBStats(name string, db *sql.DB) error {
+	tags := map[string]string{"db": name}
+	ids := make(map[string]int)
+	for _, metric := range []string{SQLMaxOpen, SQLOpen, SQLInUse, SQLIdle, SQLWaitCount, SQLWaitDuration} {
+		id, err := m.RegisterMetric(metric, GaugeMetric, "sql "+metric, tags)
+		if err != nil {
+			return err
+		}
+		ids[metric] = id
+	}
+
+	m.AddCollector(dbStatsCollector(name), func() {
+		stats := db.Stats()
+		m.SetPersistent(ids[SQLMaxOpen], GaugeMetric, float64(stats.MaxOpenConnections))
+		m.SetPersistent(ids[SQLOpen], GaugeMetric, float64(stats.OpenConnections))
+		m.SetPersistent(ids[SQLInUse], GaugeMetric, float64(stats.InUse))
+		m.SetPersistent(ids[SQLIdle], GaugeMetric, float64(stats.Idle))
+		m.SetPersistent(ids[SQLWaitCount], GaugeMetric, float64(stats.WaitCount))
+		m.SetPersistent(ids[SQLWaitDuration], GaugeMetric, stats.WaitDuration.Seconds()*1000)
+	})
+	return nil
+}
+
+// UnregisterDBStats 停止采集 RegisterDBStats 注册的 db 连接池的统计
+func (m *MONITOR) UnregisterDBStats(name string) {
+	m.RemoveCollector(dbStatsCollector(name))
+}
+
+// dbStatsCollector 返回 db 连接池统计的采集函数名
+func dbStatsCollector(name string) string {
+	return "sql.db." + name
+}
@@ -0,0 +1,166 @@
+package monitor
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// fakeDriver 内存中的 driver, 只实现必需的接口, 查询包含 fail 时返回错误
+type fakeDriver struct{}
+
+type fakeConn struct{}
+
+type fakeStmt struct {
+	query string
+}
+
+type fakeTx struct{}
+
+type fakeRows struct {
+	done bool
+}
+
+var errFakeQuery = errors.New("fake query error")
+
+func (fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{query: query}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.Contains(s.query, "fail") {
+		return nil, errFakeQuery
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if strings.Contains(s.query, "fail") {
+		return nil, errFakeQuery
+	}
+	return &fakeRows{}, nil
+}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func (r *fakeRows) Columns() []string { return []string{"n"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(1)
+	return nil
+}
+
+func TestSQLDriver(t *testing.T) {
+	m, _ := New(NewConfig())
+	m.RegisterDriver("monitor-fake", "users", fakeDriver{})
+
+	db, err := sql.Open("monitor-fake", "")
+	if err != nil {
+		t.Fatalf("open error %s", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(3)
+	if err := m.RegisterDBStats("users", db); err != nil {
+		t.Fatalf("register db stats error %s", err)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT 1").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("query row %d error %v", n, err)
+	}
+	if _, err := db.Exec("UPDATE users SET a = ?", 1); err != nil {
+		t.Fatalf("exec error %s", err)
+	}
+	if _, err := db.Exec("UPDATE fail"); err != errFakeQuery {
+		t.Fatalf("exec error %v, want %v", err, errFakeQuery)
+	}
+	tx, _ := db.Begin()
+	tx.Commit()
+
+	points := collectPoints(m.Core.MetricMap, m.Tick())
+	count := func(op, outcome string) float64 {
+		return pointValue(t, points, SQLOperations, map[string]string{"db": "users", "op": op, OutcomeTag: outcome}, "Count")
+	}
+	if got := count(sqlOpQuery, OutcomeSuccess); got != 1 {
+		t.Fatalf("query count %v, want 1", got)
+	}
+	if got := count(sqlOpExec, OutcomeSuccess); got != 1 {
+		t.Fatalf("exec count %v, want 1", got)
+	}
+	if got := count(sqlOpExec, OutcomeError); got != 1 {
+		t.Fatalf("exec error count %v, want 1", got)
+	}
+	// driver 不支持 ExecerContext, database/sql 改用 prepare, ErrSkip 不记录
+	if got := count(sqlOpPrepare, OutcomeSuccess); got != 3 {
+		t.Fatalf("prepare count %v, want 3", got)
+	}
+	if got := count(sqlOpBegin, OutcomeSuccess); got != 1 || count(sqlOpCommit, OutcomeSuccess) != 1 {
+		t.Fatalf("begin count %v, commit count %v", got, count(sqlOpCommit, OutcomeSuccess))
+	}
+
+	// 切换前采集连接池的统计
+	tags := map[string]string{"db": "users"}
+	if got := pointValue(t, points, SQLMaxOpen, tags, "Last"); got != 3 {
+		t.Fatalf("max open %v, want 3", got)
+	}
+	if got := pointValue(t, points, SQLOpen, tags, "Last"); got < 1 {
+		t.Fatalf("open connections %v, want >= 1", got)
+	}
+}
+
+func TestCollectorPanic(t *testing.T) {
+	m, _ := New(NewConfig())
+	called := 0
+	m.AddCollector("panic", func() { panic("boom") })
+	m.AddCollector("count", func() { called++ })
+
+	m.Tick()
+	m.Tick()
+	if called != 2 {
+		t.Fatalf("collector called %d times, want 2", called)
+	}
+}
+
+func TestCollectorReplaceRemove(t *testing.T) {
+	m, _ := New(NewConfig())
+	first, second := 0, 0
+	m.AddCollector("count", func() { first++ })
+	m.AddCollector("count", func() { second++ })
+
+	// 同名的采集函数替换之前的
+	m.Tick()
+	if first != 0 || second != 1 {
+		t.Fatalf("collectors called %d %d times, want 0 1", first, second)
+	}
+
+	m.RemoveCollector("count")
+	m.RemoveCollector("missing")
+	m.Tick()
+	if second != 1 || len(m.collectors) != 0 {
+		t.Fatalf("removed collector called %d times, %d collectors left", second, len(m.collectors))
+	}
+
+	// 同一个 db 重复注册只采集一次, 注销后不再采集
+	db, _ := sql.Open("monitor-fake", "")
+	defer db.Close()
+	m.RegisterDBStats("users", db)
+	m.RegisterDBStats("users", db)
+	if len(m.collectors) != 1 {
+		t.Fatalf("%d collectors after registering db stats twice, want 1", len(m.collectors))
+	}
+	m.UnregisterDBStats("users")
+	if len(m.collectors) != 0 {
+		t.Fatalf("%d collectors after unregister db stats", len(m.collectors))
+	}
+}
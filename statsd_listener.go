@@ -0,0 +1,194 @@
+package monitor
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// StatsD / DogStatsD 的 udp 接收端, 接收其它进程发送的 name:value|type[|@rate][|#tags] 格式数据
+// c  计数, 无 tags 时调用 Add, 否则记录为 SumMetric
+// g  gauge, 无 tags 时调用 Set, 否则记录为 GaugeMetric; +/- 开头的值为增量
+// ms h d 时间及分布, 记录为 QuantileMetric
+
+const (
+	// SelfStatsdBadLines statsd 接收端无法解析的行数
+	SelfStatsdBadLines = SelfMonitorKey + ".StatsdBadLines"
+
+	// statsdMaxPacket 接收的 udp 包的最大长度
+	statsdMaxPacket = 65535
+)
+
+// statsdLine 一行 statsd 数据
+type statsdLine struct {
+	name  string
+	value float64
+	delta bool // gauge 的值是否为增量
+	kind  string
+	tags  map[string]string
+}
+
+// StartStatsdListener 启动 statsd 的 udp 接收端, 端口校验同 ValidateStatsdPort 并更新到配置中
+func (m *MONITOR) StartStatsdListener(port int) error {
+	if port == 0 {
+		return &ErrStatsdPort{Port: port}
+	}
+	if err := m.Conf.ValidateStatsdPort(port); err != nil {
+		return err
+	}
+
+	conn, err := net.ListenPacket(UDPProtocol, ":"+strconv.Itoa(port))
+	if err != nil {
+		Logger.Error("start statsd listener error", "port", port, LogKeyErr, err)
+		return err
+	}
+
+	m.Lock()
+	m.statsdConn = conn
+	m.Unlock()
+
+	go m.serveStatsd(conn)
+
+	Logger.Info("start monitor statsd listener done", "port", port)
+	return nil
+}
+
+// stopStatsdListener 关闭 statsd 的接收端
+func (m *MONITOR) stopStatsdListener() {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.statsdConn != nil {
+		m.statsdConn.Close()
+		m.statsdConn = nil
+	}
+}
+
+// serveStatsd 循环读取 udp 包, 连接关闭后退出
+func (m *MONITOR) serveStatsd(conn net.PacketConn) {
+	buf := make([]byte, statsdMaxPacket)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			Logger.Info("statsd listener quit", LogKeyErr, err)
+			return
+		}
+		m.handleStatsdPacket(string(buf[:n]))
+	}
+}
+
+// handleStatsdPacket 处理一个 udp 包, 一个包中可能包含多行
+func (m *MONITOR) handleStatsdPacket(packet string) {
+	defer m.recoverRecord("handleStatsdPacket")
+
+	for _, raw := range strings.Split(packet, "\n") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		line, ok := parseStatsdLine(raw)
+		if !ok {
+			m.addSelf(SelfStatsdBadLines, 1)
+			Logger.Debug("statsd bad line", "line", raw)
+			continue
+		}
+		m.recordStatsdLine(line)
+	}
+}
+
+// recordStatsdLine 按类型将一行数据记录到当前周期
+func (m *MONITOR) recordStatsdLine(line *statsdLine) {
+	switch line.kind {
+	case "c":
+		if len(line.tags) == 0 {
+			m.Add(line.name, line.value)
+			return
+		}
+		if id, err := m.RegisterMetric(line.name, SumMetric, "statsd counter", line.tags); err == nil {
+			m.AddPersistent(id, SumMetric, line.value)
+		}
+	case "g":
+		if len(line.tags) == 0 {
+			if line.delta {
+				m.Add(line.name, line.value)
+			} else {
+				m.Set(line.name, line.value)
+			}
+			return
+		}
+		if id, err := m.RegisterMetric(line.name, GaugeMetric, "statsd gauge", line.tags); err == nil {
+			if line.delta {
+				m.AddPersistent(id, GaugeMetric, line.value)
+			} else {
+				m.SetPersistent(id, GaugeMetric, line.value)
+			}
+		}
+	case "ms", "h", "d":
+		if id, err := m.RegisterMetric(line.name, QuantileMetric, "statsd timer", line.tags); err == nil {
+			m.AddPersistent(id, QuantileMetric, line.value)
+		}
+	}
+}
+
+// parseStatsdLine 解析一行 statsd 数据, 格式错误或不支持的类型返回 false
+func parseStatsdLine(raw string) (*statsdLine, bool) {
+	colon := strings.LastIndexByte(strings.SplitN(raw, "|", 2)[0], ':')
+	if colon <= 0 {
+		return nil, false
+	}
+
+	parts := strings.Split(raw[colon+1:], "|")
+	if len(parts) < 2 {
+		return nil, false
+	}
+
+	line := &statsdLine{name: raw[:colon], kind: parts[1]}
+	switch line.kind {
+	case "c", "g", "ms", "h", "d":
+	default:
+		return nil, false
+	}
+
+	value, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return nil, false
+	}
+	line.value = value
+	line.delta = line.kind == "g" && (parts[0][0] == '+' || parts[0][0] == '-')
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, false
+			}
+			// 采样的计数按采样率还原
+			if line.kind == "c" {
+				line.value /= rate
+			}
+		case strings.HasPrefix(part, "#"):
+			line.tags = parseDogStatsdTags(part[1:])
+		}
+	}
+
+	return line, true
+}
+
+// parseDogStatsdTags 解析 DogStatsD 的 tags, 格式为 k1:v1,k2:v2, 无值的 tag 值为空
+func parseDogStatsdTags(raw string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(raw, ",") {
+		if tag == "" {
+			continue
+		}
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) == 2 {
+			tags[kv[0]] = kv[1]
+		} else {
+			tags[kv[0]] = ""
+		}
+	}
+	return tags
+}
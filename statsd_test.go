@@ -0,0 +1,150 @@
+package monitor
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStatsdLine(t *testing.T) {
+	cases := []struct {
+		raw   string
+		ok    bool
+		name  string
+		value float64
+		kind  string
+		delta bool
+		tags  map[string]string
+	}{
+		{raw: "page.views:1|c", ok: true, name: "page.views", value: 1, kind: "c"},
+		{raw: "page.views:1|c|@0.5", ok: true, name: "page.views", value: 2, kind: "c"},
+		{raw: "queue:-3|g", ok: true, name: "queue", value: -3, kind: "g", delta: true},
+		{raw: "db.query:12.5|ms|#route:/users,env:prod", ok: true, name: "db.query", value: 12.5, kind: "ms",
+			tags: map[string]string{"route": "/users", "env": "prod"}},
+		{raw: "users:alice|s"},
+		{raw: "bad|c"},
+		{raw: "bad:x|c"},
+		{raw: "bad:1|c|@2"},
+	}
+
+	for _, c := range cases {
+		line, ok := parseStatsdLine(c.raw)
+		if ok != c.ok {
+			t.Errorf("parse %q ok = %v, want %v", c.raw, ok, c.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if line.name != c.name || line.value != c.value || line.kind != c.kind || line.delta != c.delta {
+			t.Errorf("parse %q = %+v", c.raw, line)
+		}
+		if metricKey("", line.tags) != metricKey("", c.tags) {
+			t.Errorf("parse %q tags = %v, want %v", c.raw, line.tags, c.tags)
+		}
+	}
+}
+
+func TestHandleStatsdPacket(t *testing.T) {
+	m, _ := New(NewConfig())
+
+	m.handleStatsdPacket("page.views:1|c\npage.views:2|c\nqueue:5|g\nqueue:+2|g\n" +
+		"db.query:10|ms|#route:/users\ndb.query:30|ms|#route:/users\nerrors:1|c|#code:500\nbad line")
+
+	now := m.Core.NowMonitor
+	if v, _ := now.Get("page.views"); v != 3 {
+		t.Errorf("page.views = %v, want 3", v)
+	}
+	if v, _ := now.Get("queue"); v != 7 {
+		t.Errorf("queue = %v, want 7", v)
+	}
+	if v, _ := now.Get(SelfStatsdBadLines); v != 1 {
+		t.Errorf("bad lines = %v, want 1", v)
+	}
+
+	id, ok := m.getCallNameMaeID("db.query;route=/users")
+	if !ok {
+		t.Fatal("db.query not registered")
+	}
+	if sv := now.GetPersistent(id); sv.Otd.Count() != 2 {
+		t.Errorf("db.query count = %d, want 2", sv.Otd.Count())
+	}
+
+	id, ok = m.getCallNameMaeID("errors;code=500")
+	if !ok || now.GetPersistent(id).Sum != 1 || m.Core.MetricMap.Map[id].Type != SumMetric {
+		t.Errorf("errors not recorded as SumMetric")
+	}
+}
+
+func TestStatsdListener(t *testing.T) {
+	// 获取一个空闲端口
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+
+	m, _ := New(NewConfig())
+	for _, bad := range []int{0, 1024, 65535} {
+		if _, ok := m.StartStatsdListener(bad).(*ErrStatsdPort); !ok {
+			t.Fatalf("expect ErrStatsdPort for port %d", bad)
+		}
+	}
+	if _, ok := m.Conf.ValidateStatsdPort(1024).(*ErrStatsdPort); !ok {
+		t.Fatal("expect ErrStatsdPort from ValidateStatsdPort")
+	}
+	if err := m.StartStatsdListener(port); err != nil {
+		t.Fatalf("start statsd listener error %s", err)
+	}
+	defer m.stopStatsdListener()
+
+	conn, _ := net.Dial("udp", pc.LocalAddr().String())
+	conn.Write([]byte("sidecar.count:4|c"))
+	conn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if v, _ := m.Core.NowMonitor.Get("sidecar.count"); v == 4 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("statsd packet not recorded")
+}
+
+func TestStatsdWriter(t *testing.T) {
+	pc, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	defer pc.Close()
+
+	conf := NewWriterConfig()
+	conf.ValidWriterName("DogStatsdWriter")
+	conf.ValidateMode(UP)
+	conf.UpLoadHost = "127.0.0.1"
+	conf.ValidateUpLoadPort(pc.LocalAddr().(*net.UDPAddr).Port)
+
+	w, _ := InitWriter(conf)
+	nameMap, omd := newTestStorage(t)
+	omd.Data["temperature"] = -2
+	if err := w.DoWithRecover(nameMap, omd); err != nil {
+		t.Fatalf("statsd writer error %s", err)
+	}
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read udp error %s", err)
+	}
+
+	got := string(buf[:n])
+	host := "|#host:" + HostName
+	for _, line := range []string{
+		"api_latency_Count:2|c" + host + ",route:/users",
+		"api_latency_Avg:20|g" + host + ",route:/users",
+		"biz.count:3|g" + host,
+		"temperature:0|g" + host + "\ntemperature:-2|g" + host,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("packet %q missing line %q", got, line)
+		}
+	}
+}
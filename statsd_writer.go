@@ -0,0 +1,121 @@
+package monitor
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// StatsD / DogStatsD 的 writer, 将每个周期的聚合值通过 udp 发送
+// Count 和 Sum 的值以 counter(c) 发送, 其余的以 gauge(g) 发送
+
+// init 注册 StatsdWriter 及 DogStatsdWriter
+func init() {
+	RegisterWriterName["StatsdWriter"] = func(conf *WriterConfig) Writer {
+		return &StatsdWriter{
+			Conf:     conf,
+			Describe: "A StatsD udp writer",
+		}
+	}
+
+	RegisterWriterName["DogStatsdWriter"] = func(conf *WriterConfig) Writer {
+		return &StatsdWriter{
+			Conf:     conf,
+			Describe: "A DogStatsD udp writer, with tags",
+			WithTags: true,
+		}
+	}
+}
+
+const (
+	// statsdUDPPayload 单个 udp 包的最大长度, 多个指标以换行分割
+	statsdUDPPayload = 1432
+)
+
+// StatsdWriter StatsD 的 writer
+// WithTags 为 true 时以 DogStatsD 的 |#k:v 格式发送 tags 及 host
+type StatsdWriter struct {
+	Conf     *WriterConfig
+	Describe string
+	WithTags bool
+}
+
+// DoWithRecover 处理一分钟的数据
+func (j *StatsdWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			Logger.Error("writer panic at DoWithRecover", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyPanic, p)
+			err = &ErrWriterPanic{Name: j.Conf.Name, Panic: p}
+		}
+	}()
+
+	points := writerPoints(j.Conf, nameMap, omd)
+	if j.WithTags {
+		points = pointsWithHost(points)
+	}
+
+	var buf bytes.Buffer
+	writeStatsdLines(&buf, points, j.WithTags)
+
+	addr := net.JoinHostPort(j.Conf.UpLoadHost, strconv.Itoa(j.Conf.UpLoadPort))
+	err = uploadWithRetry(j.Conf.UploadRetry, func() error {
+		return sendUDPLines(addr, buf.Bytes(), statsdUDPPayload)
+	})
+	if err != nil {
+		Logger.Error("writer upload error", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
+	}
+	return
+}
+
+// statsd 中有特殊含义的字符
+var (
+	statsdNameEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", " ", "_", "\n", "_")
+	statsdTagEscaper  = strings.NewReplacer(":", "_", "|", "_", ",", "_", "#", "_", "\n", "_")
+)
+
+// writeStatsdLines 将 Point 列表格式化为 statsd 的行
+func writeStatsdLines(buf *bytes.Buffer, points []*Point, withTags bool) {
+	for _, p := range points {
+		tags := ""
+		if withTags && len(p.Tags) > 0 {
+			pairs := make([]string, 0, len(p.Tags))
+			for _, k := range p.SortedTagKeys() {
+				pairs = append(pairs, statsdTagEscaper.Replace(k)+":"+statsdTagEscaper.Replace(p.Tags[k]))
+			}
+			tags = "|#" + strings.Join(pairs, ",")
+		}
+
+		for i, value := range p.Values {
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+
+			kind := "g"
+			if !p.Plain {
+				switch p.FieldName(i) {
+				case "Count", "Sum":
+					kind = "c"
+				}
+			}
+
+			name := statsdNameEscaper.Replace(p.Name + p.Suffix[i])
+			// gauge 的负值会被当作减量, 需要先置为 0
+			if kind == "g" && value < 0 {
+				buf.WriteString(name)
+				buf.WriteString(":0|g")
+				buf.WriteString(tags)
+				buf.WriteByte(NewLine)
+			}
+
+			buf.WriteString(name)
+			buf.WriteByte(':')
+			buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+			buf.WriteByte('|')
+			buf.WriteString(kind)
+			buf.WriteString(tags)
+			buf.WriteByte(NewLine)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package monitor
 
 import (
 	"sync"
+	"time"
 )
 
 const (
@@ -20,6 +21,8 @@ type Storage struct {
 	HistoryMonitor       []*OneMinStorage //历史的监控数据
 	HistoryVersionNumber int              // 历史版本数
 	Cursor               int              // 历史版本游标
+	Interval             time.Duration    // 聚合周期, 用于对齐版本的 Ts, 为 0 时不对齐
+	Clock                Clock            // 时钟, 用于版本的 Ts
 }
 
 // NewStorage 初始化一个核心的存储
@@ -30,6 +33,7 @@ func NewStorage(history int) *Storage {
 		HistoryMonitor:       make([]*OneMinStorage, history+1),
 		HistoryVersionNumber: history,
 		Cursor:               0,
+		Clock:                RealClock,
 	}
 
 	s.NowMonitor = NewOneMinStorage()
@@ -38,46 +42,131 @@ func NewStorage(history int) *Storage {
 }
 
 // nextSpecValue 生成新的模版
-func (s *Storage) nextSpecValue() map[int]*SpecValue {
+// carry 为需要在切换时从上一个周期延续值的指标, 如 gauge 的最后值及累计值, 参考 carryOver
+func (s *Storage) nextSpecValue() (template map[int]*SpecValue, carry map[int]*MetricName) {
 	s.MetricMap.RLock()
-	template := make(map[int]*SpecValue, len(s.MetricMap.Map))
+	defer s.MetricMap.RUnlock()
+
+	template = make(map[int]*SpecValue, len(s.MetricMap.Map))
+	carry = make(map[int]*MetricName)
 
 	for k, metric := range s.MetricMap.Map {
 
-		v, err := initSpecValue(metric.Type)
+		v, err := initSpecValue(metric)
 		if err != nil {
-			Logger.Printf("Init SpecValue Error : %s", err)
+			Logger.Error("init spec value error", LogKeyMetric, metric.Name, LogKeyErr, err)
 			continue
 		}
 
 		template[k] = v
+		if metric.Type == GaugeMetric || metric.Type == RateMetric || metric.Cumulative {
+			carry[k] = metric
+		}
 	}
 
-	s.MetricMap.RUnlock()
-	return template
+	return
 }
 
 // NextMonitor 切换监控版本数据, 迭代下一版数据
 // 返回当前版本的数据
 func (s *Storage) NextMonitor() (now *OneMinStorage) {
+	start := s.Clock.Now()
+
 	// 初始化 SpecValue
 	next := NewOneMinStorage()
-	next.PersistentData = s.nextSpecValue()
+	next.Ts = alignTime(start, s.Interval)
+	var carry map[int]*MetricName
+	next.PersistentData, carry = s.nextSpecValue()
+
+	now = s.swap(next, carry)
+	now.Lock()
+	now.End = next.Ts
+	series := now.seriesLen()
+	now.Unlock()
+
+	// 自身监控: 序列数及切换耗时, 记录在切换出来的版本中, 随 Writer 一起输出
+	now.Set(SelfNextMonitorDuration, s.Clock.Now().Sub(start).Seconds()*1000)
+	now.Set(SelfSeriesNum, float64(series))
+
+	return
+}
+
+// carryOver 将 carry 中的指标的值从 prev 带入 next, 如 gauge 的最后值及累计值
+// 速率类型先按 prev 到 next 的实际时长计算 prev 的速率, 再将 EWMA 带入 next
+// next 尚未切换为当前版本, 不需要加锁; prev 由调用方加锁
+func carryOver(prev, next *OneMinStorage, carry map[int]*MetricName) {
+	seconds := next.Ts.Sub(prev.Ts).Seconds()
+
+	for id, metric := range carry {
+		sv, ok := next.PersistentData[id]
+		if !ok {
+			continue
+		}
+		if pv, ok := prev.PersistentData[id]; ok {
+			if metric.Type == RateMetric {
+				pv.Lock()
+				pv.finishRate(seconds)
+				pv.Unlock()
+			}
+
+			pv.RLock()
+			if metric.Type == GaugeMetric {
+				sv.carryGauge(pv)
+			}
+			if metric.Type == RateMetric {
+				sv.EWMA = pv.EWMA
+			}
+			if metric.Cumulative {
+				sv.carryTotal(pv)
+			}
+			pv.RUnlock()
+		}
+	}
+}
 
+// History 返回第 n 个历史版本, 1 为最后一个已完成的周期, 不存在时返回 nil
+// n 不在 1 到 HistoryVersionNumber 之间, 或还没有切换出这么多版本时不存在
+func (s *Storage) History(n int) *OneMinStorage {
+	if n <= 0 || n > s.HistoryVersionNumber {
+		return nil
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+
+	idx := s.Cursor - n
+	if idx < 0 {
+		idx += s.HistoryVersionNumber
+	}
+	return s.HistoryMonitor[idx]
+}
+
+// swap 将 next 切换为当前版本, 并将原当前版本存入历史
+// carry 中的指标在切换时从原当前版本延续值
+// 返回切换出来的版本
+func (s *Storage) swap(next *OneMinStorage, carry map[int]*MetricName) (now *OneMinStorage) {
 	s.Lock()
+	defer s.Unlock()
+
 	// 记录当前的监控数据,并返回交友切换代码做后续 上传 or 落地
 	now = s.NowMonitor
+
+	// 延续上一个周期的值, 切换前锁住当前版本, 保证延续的是切换时的最终值
+	now.Lock()
+	carryOver(now, next, carry)
 	// 切换 及 判断
 	s.NowMonitor = next
+	now.Unlock()
 
-	// 数据添加到历史版本中,并移动游标
+	// 数据添加到历史版本中,并移动游标, 不保留历史版本时不处理
+	if s.HistoryVersionNumber <= 0 {
+		return
+	}
 	s.HistoryMonitor[s.Cursor] = now
 	if s.Cursor == s.HistoryVersionNumber-1 {
 		s.Cursor = -1
 	}
 	s.Cursor++
 
-	s.Unlock()
-
 	return
 }
@@ -3,6 +3,7 @@ package monitor
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	td "github.com/caio/go-tdigest"
 )
@@ -28,6 +29,18 @@ const (
 	// 格式化输出时,可能返回多个值
 	// QuantileMetric 分位数指标
 	QuantileMetric
+	// HistogramMetric 固定分桶的直方图指标
+	HistogramMetric
+	// GaugeMetric 瞬时值指标, 记录周期内的 最后值 最小值 最大值 平均值, 最后值延续到下一个周期
+	GaugeMetric
+	// RateMetric 速率指标, 记录事件数, 输出按周期实际时长计算的每秒速率及 1/5/15 个周期的 EWMA
+	RateMetric
+	// CardinalityMetric 去重计数指标, 使用 HyperLogLog 估计周期内不同 key 的个数
+	CardinalityMetric
+	// TopKMetric 记录出现最多的 key, 每个周期按 key 输出计数最多的 K 个
+	TopKMetric
+	// TimerMetric 耗时指标, 记录 计数 总和 平均值 最小值 最大值 及分位数, 单位参考 WithTimeUnit
+	TimerMetric
 )
 
 // 特殊指标名类型下定义及初始化等
@@ -39,6 +52,28 @@ type MetricName struct {
 	Describe   string            // 描述信息
 	Type       int               // 指标类型
 	SortedTags [][]byte          // 排过序的 byte 数组, 应该每次Tags 有变化的时候重新生成
+
+	// 分位数类型的配置, 参考 WithQuantiles WithCompression
+	Quantiles   []float64 // 输出的分位点, 为空时使用默认分位点
+	Compression float64   // t-digest 的压缩参数, 为 0 时使用默认值
+
+	// 耗时类型的配置, 参考 WithTimeUnit
+	Unit     string        // 单位的名称, 如 ms, 输出到支持单位的格式中
+	UnitSize time.Duration // 单位的时长, 为 0 时为 DefaultTimeUnit
+
+	// 直方图类型的配置, 参考 WithBuckets
+	Buckets []float64 // 桶的上界, 为空时使用 DefaultBuckets
+
+	// 去重计数类型的配置, 参考 WithHLLPrecision
+	HLLPrecision int // HyperLogLog 的精度, 为 0 时使用 DefaultHLLPrecision
+
+	// Top-K 类型的配置, 参考 WithTopK WithTopKCapacity
+	TopK         int // 输出的 key 个数, 为 0 时使用 DefaultTopK
+	TopKCapacity int // 计数器个数, 为 0 时为 TopK 的 topKCapacityFactor 倍
+
+	// 累计值的配置, 参考 WithCumulative
+	Cumulative bool      // 是否输出累计值
+	Created    time.Time // 指标注册的时间, 即累计值的开始时间
 }
 
 func (m *MetricName) String() string {
@@ -46,14 +81,61 @@ func (m *MetricName) String() string {
 		m.Describe, m.Name, m.Type, m.SortedTags)
 }
 
-// initMetricName 初始化指标名
-func initMetricName(name string, t int, describe string, tags map[string]string) *MetricName {
-	return &MetricName{
+// initMetricName 初始化指标名, 并应用指标的可选配置
+func initMetricName(name string, t int, describe string, tags map[string]string,
+	opts ...MetricOption) (*MetricName, error) {
+
+	m := &MetricName{
 		Name:     name,
 		Tags:     tags,
 		Describe: describe,
 		Type:     t,
 	}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	// Top-K 指标输出时以 TopKTag 记录 key, 不能与已有的 tag 重名
+	if _, ok := tags[TopKTag]; ok && t == TopKMetric {
+		return nil, &ErrMetricOption{Name: name, Msg: "tag " + TopKTag + " is reserved for TopKMetric"}
+	}
+	return m, nil
+}
+
+// quantiles 返回分位数类型输出的分位点
+func (m *MetricName) quantiles() []float64 {
+	if len(m.Quantiles) > 0 {
+		return m.Quantiles
+	}
+	return defaultQuantiles
+}
+
+// buckets 返回直方图类型的桶上界
+func (m *MetricName) buckets() []float64 {
+	if len(m.Buckets) > 0 {
+		return m.Buckets
+	}
+	return DefaultBuckets
+}
+
+// Suffixes 返回指标输出的后缀, 分位数及直方图类型根据配置生成, 其余参考 SuffixMap
+// 配置了累计值时在最后添加 CumulativeSuffix
+func (m *MetricName) Suffixes() []string {
+	suffix := SuffixMap[m.Type]
+	if m.Type == QuantileMetric && len(m.Quantiles) > 0 {
+		suffix = quantileSuffixes(m.Quantiles)
+	}
+	if m.Type == TimerMetric && len(m.Quantiles) > 0 {
+		suffix = append(quantileSuffixes(m.Quantiles), timerStatSuffix...)
+	}
+	if m.Type == HistogramMetric && len(m.Buckets) > 0 {
+		suffix = histogramSuffixes(m.Buckets)
+	}
+	if m.Cumulative {
+		return append(suffix[:len(suffix):len(suffix)], CumulativeSuffix...)
+	}
+	return suffix
 }
 
 // GetSortedTags 格式化 tags , 排序并返回
@@ -83,19 +165,47 @@ func NewMetricNameMap() *MetricNameMap {
 // SpecValue 特殊值组合, 应该根据指标类型特别处理
 type SpecValue struct {
 	sync.RWMutex
-	Sum   float64     // 总和
-	Count int64       // 计数
-	Otd   *td.TDigest // 分位数
+	Sum     float64     // 总和
+	Count   int64       // 计数
+	Min     float64     // 最小值, 分位数及 gauge 类型记录
+	Max     float64     // 最大值, 分位数及 gauge 类型记录
+	Last    float64     // 最后值, gauge 类型记录
+	HasLast bool        // Last 是否有值, 包括从上一个周期延续的, gauge 类型记录
+	Otd     *td.TDigest // 分位数
+
+	Bounds  []float64 // 直方图桶的上界, 与指标配置共享, 只读
+	Buckets []int64   // 直方图各桶的计数, 非累计, 最后一个为 +Inf
+
+	HLL  *HyperLogLog // 去重计数类型的 sketch
+	TopK *SpaceSaving // Top-K 类型的计数器
+
+	Rate float64    // 每秒速率, 周期切换时按实际时长计算, 之前为 NaN
+	EWMA [3]float64 // 1/5/15 个周期的平滑速率, 从上一个周期延续, 第一次计算前为 NaN
+
+	TotalSum   float64 // 本周期之前的累计总和, 配置了 WithCumulative 时记录
+	TotalCount int64   // 本周期之前的累计计数, 配置了 WithCumulative 时记录
+
+	TotalBuckets []int64 // 本周期之前直方图各桶的累计计数, 非按桶累计, 配置了 WithCumulative 时记录
 }
 
 func (s *SpecValue) String() string {
+	return s.format(6)
+}
+
+// format 返回可读的特殊值, Sum 按 precision 位小数格式化, 参考 formatFloat
+func (s *SpecValue) format(precision int) string {
+	sum := formatFloat(s.Sum, precision)
+	if s.TopK != nil {
+		return fmt.Sprintf("SpecValue:\n\tSum: %s\n\tCount: %d\n\tTopK:\n%s",
+			sum, s.Count, topKString(s.TopK))
+	}
 	if s.Otd != nil {
-		return fmt.Sprintf("SpecValue:\n\tSum: %f\n\tCount: %d\n\tTdigest: %d",
-			s.Sum, s.Count, s.Otd.Count())
+		return fmt.Sprintf("SpecValue:\n\tSum: %s\n\tCount: %d\n\tTdigest: %d",
+			sum, s.Count, s.Otd.Count())
 	}
 
-	return fmt.Sprintf("SpecValue:\n\tSum: %f\n\tCount: %d\n",
-		s.Sum, s.Count)
+	return fmt.Sprintf("SpecValue:\n\tSum: %s\n\tCount: %d\n",
+		sum, s.Count)
 }
 
 // newBaseSpecValue 返回无分位数的特殊数据集
@@ -107,11 +217,57 @@ func newBaseSpecValue() *SpecValue {
 	}
 }
 
+// observeMinMax 更新最小值及最大值, 需在 Count 增加之前调用
+func (s *SpecValue) observeMinMax(value float64) {
+	if s.Count == 0 || value < s.Min {
+		s.Min = value
+	}
+	if s.Count == 0 || value > s.Max {
+		s.Max = value
+	}
+}
+
+// setGauge 记录 gauge 的一次取值
+func (s *SpecValue) setGauge(value float64) {
+	s.observeMinMax(value)
+	s.Last, s.HasLast = value, true
+	s.Sum += value
+	s.Count++
+}
+
+// carryGauge 将上一个周期 gauge 的最后值延续到本周期, 只作为 Last 及增量的基础, 不计入最小值 最大值 平均值
+// 上一个周期的最后值也没有值时不处理
+func (s *SpecValue) carryGauge(prev *SpecValue) {
+	if !prev.HasLast {
+		return
+	}
+	s.Last, s.HasLast = prev.Last, true
+}
+
+// carryTotal 将上一个周期的增量累加到累计值中, 直方图同时累加各桶的计数
+func (s *SpecValue) carryTotal(prev *SpecValue) {
+	s.TotalSum = prev.TotalSum + prev.Sum
+	s.TotalCount = prev.TotalCount + prev.Count
+	if prev.Buckets != nil {
+		s.TotalBuckets = make([]int64, len(prev.Buckets))
+		for i, n := range prev.Buckets {
+			s.TotalBuckets[i] = n
+			if prev.TotalBuckets != nil {
+				s.TotalBuckets[i] += prev.TotalBuckets[i]
+			}
+		}
+	}
+}
+
 // newQuantileSpecValue 返回初始化过的含有分位数的特殊数据类型
-func newQuantileSpecValue() (*SpecValue, error) {
+// compression 为 t-digest 的压缩参数, 为 0 时使用默认值
+func newQuantileSpecValue(compression float64) (*SpecValue, error) {
 	ntd, err := td.New()
+	if compression > 0 {
+		ntd, err = td.New(td.Compression(compression))
+	}
 	if err != nil {
-		Logger.Printf("Init New Tdigest Struct Error %s", err)
+		Logger.Error("init new tdigest error", LogKeyErr, err)
 		return nil, err
 	}
 
@@ -122,9 +278,9 @@ func newQuantileSpecValue() (*SpecValue, error) {
 	}, nil
 }
 
-// initSpecValue 初始化特殊的值类型
-func initSpecValue(_type int) (*SpecValue, error) {
-	switch _type {
+// initSpecValue 根据指标的类型及配置初始化特殊的值类型
+func initSpecValue(metric *MetricName) (*SpecValue, error) {
+	switch metric.Type {
 	case BaseMetric:
 		return newBaseSpecValue(), nil
 	case SumMetric:
@@ -137,8 +293,20 @@ func initSpecValue(_type int) (*SpecValue, error) {
 		return newBaseSpecValue(), nil
 	case CountAvgMetric:
 		return newBaseSpecValue(), nil
+	case GaugeMetric:
+		return newBaseSpecValue(), nil
+	case RateMetric:
+		return newRateSpecValue(), nil
+	case CardinalityMetric:
+		return newCardinalitySpecValue(metric.HLLPrecision)
+	case TopKMetric:
+		return newTopKSpecValue(metric), nil
+	case TimerMetric:
+		return newQuantileSpecValue(metric.Compression)
 	case QuantileMetric:
-		return newQuantileSpecValue()
+		return newQuantileSpecValue(metric.Compression)
+	case HistogramMetric:
+		return newHistogramSpecValue(metric.buckets()), nil
 	}
 	return nil, &ErrUnexpectMetricType{}
 }
@@ -2,10 +2,6 @@ package monitor
 
 import (
 	"bytes"
-	"fmt"
-	"os"
-	"strconv"
-	"strings"
 )
 
 // 落地为 Text 的 writer
@@ -39,72 +35,29 @@ type TextWriter struct {
 }
 
 // DoWithRecover 处理一分钟的数据
-func (j *TextWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) error {
+func (j *TextWriter) DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) (err error) {
 	defer func() {
 		if p := recover(); p != nil {
-			Logger.Printf("TextWriter Have Panic at DoWithRecover %s", p)
+			Logger.Error("writer panic at DoWithRecover", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyPanic, p)
+			err = &ErrWriterPanic{Name: j.Conf.Name, Panic: p}
 		}
 	}()
 
-	// 初始化数据文件
-	tmpfile, err := getTempFile()
-	if err != nil {
-		Logger.Printf("TextWriter Get Temp File Fail, Error %s", err)
-		return err
-	}
-	tmpfile.WriteString(strconv.FormatInt(omd.Ts.Unix(), 10))
-	tmpfile.Write(NewLineBytes)
-
-	// 加锁
-	nameMap.RLock()
-	defer nameMap.RUnlock()
-	omd.RLock()
-	defer omd.RUnlock()
-
-	// 特殊监控值写入到临时文件中
-	err = writeTextSpecMetrics(tmpfile, nameMap.Map, omd.PersistentData)
-	if err != nil {
-		Logger.Printf("TextWriter Write Spec Metric on Temp File Error %s", err)
-		return err
-	}
-	// 普通的监控数据写入到临时文件
-	err = writeTextBasicMetrics(tmpfile, omd.Data)
-	if err != nil {
-		Logger.Printf("TextWriter Write Basic Metric on Temp File Error %s", err)
+	// 格式化为文本, 原子地替换落地文件
+	var buf bytes.Buffer
+	if err = newTextFormatter(j.Conf).Format(&buf, NewSnapshot(nameMap, omd).Round(j.Conf.Precision)); err != nil {
+		Logger.Error("writer format error", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
 		return err
 	}
 
-	// 关闭文件 重命名
-	tmpfile.Close()
-	err = os.Rename(tmpfile.Name(), j.Conf.DownPath)
-	if err != nil {
-		// Logger.Printf("TextWriter Write Rename Temp File Error %s", err)
-		fmt.Printf("TextWriter Write Rename Temp File Error %s", err)
+	if err = writeFileAtomic(j.Conf.DownPath, buf.Bytes(), fileMode(j.Conf)); err != nil {
+		Logger.Error("writer write file error", LogKeyWriter, j.Conf.Name, LogKeyTs, omd.Ts.Unix(), LogKeyErr, err)
 		return err
 	}
 
 	return nil
 }
 
-// 特殊监控值写入到临时文件中
-func writeTextSpecMetrics(f *os.File, nameMap map[int]*MetricName, data map[int]*SpecValue) (err error) {
-	var buf strings.Builder
-
-	for id, SPV := range data {
-		_name := nameMap[id].Name
-		_tags := GetSortedTagsString(nameMap[id].GetSortedTags())
-		values := getValueString(nameMap[id].Type, SPV)
-		suffix := SuffixMap[nameMap[id].Type]
-
-		for i, value := range values {
-			fmt.Fprintf(&buf, "%s%s%s=%s\n", _name, suffix[i], _tags, value)
-		}
-	}
-
-	_, err = f.WriteString(buf.String())
-	return
-}
-
 // GetSortedTagsString 格式化tag 字符串并返回
 func GetSortedTagsString(SortedTags [][]byte) (ret string) {
 	if len(SortedTags) == 0 {
@@ -122,15 +75,3 @@ func GetSortedTagsString(SortedTags [][]byte) (ret string) {
 
 	return
 }
-
-// 普通的监控数据写入到临时文件
-func writeTextBasicMetrics(f *os.File, data map[string]float64) (err error) {
-	var buf strings.Builder
-
-	for name, value := range data {
-		fmt.Fprintf(&buf, "%s=%f\n", name, value)
-	}
-
-	_, err = f.WriteString(buf.String())
-	return
-}
@@ -0,0 +1,170 @@
+package monitor
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// 耗时指标, 一个序列中记录 计数 总和 平均值 最小值 最大值 及分位数
+// 耗时按 WithTimeUnit 配置的单位换算后记录, 单位名称作为元数据输出到支持单位的格式中, 如 OTLP 及 JSON
+
+const (
+	// DefaultTimeUnit 耗时指标的默认单位, 与 RecordFuncTimeAvg 一致为毫秒
+	DefaultTimeUnit = time.Millisecond
+
+	// FuncTimerSuffix 函数名已注册为其它类型时, 如同一函数还使用了 RecordFuncTimeAvg
+	// RecordFuncTimer 改以 函数名+FuncTimerSuffix 注册耗时指标, 两个指标都记录
+	FuncTimerSuffix = ".Timer"
+	// FuncTimeAvgSuffix 函数名已注册为其它类型时 RecordFuncTimeAvg 使用的后缀, 同 FuncTimerSuffix
+	FuncTimeAvgSuffix = ".Avg"
+)
+
+// timeUnitNames 支持的单位及名称, 名称使用 UCUM 的写法
+var timeUnitNames = map[time.Duration]string{
+	time.Nanosecond:  "ns",
+	time.Microsecond: "us",
+	time.Millisecond: "ms",
+	time.Second:      "s",
+}
+
+// WithTimeUnit 耗时指标记录的单位, 只支持 time.Nanosecond Microsecond Millisecond Second
+// 未配置时为 DefaultTimeUnit
+func WithTimeUnit(unit time.Duration) MetricOption {
+	return func(m *MetricName) error {
+		if m.Type != TimerMetric {
+			return &ErrMetricOption{Name: m.Name, Msg: "time unit only for TimerMetric"}
+		}
+		name, ok := timeUnitNames[unit]
+		if !ok {
+			return &ErrMetricOption{Name: m.Name, Msg: "time unit must be ns, us, ms or s"}
+		}
+		m.Unit, m.UnitSize = name, unit
+		return nil
+	}
+}
+
+// timeUnit 返回耗时指标的单位及名称, 未配置时为 DefaultTimeUnit
+func (m *MetricName) timeUnit() (time.Duration, string) {
+	if m.UnitSize > 0 {
+		return m.UnitSize, m.Unit
+	}
+	return DefaultTimeUnit, timeUnitNames[DefaultTimeUnit]
+}
+
+// MetricTimer 已注册的耗时指标, 并发安全
+type MetricTimer struct {
+	m    *MONITOR
+	id   int
+	unit time.Duration
+}
+
+// RegisterTimer 注册一个耗时指标, 同 RegisterMetric, opts 可使用 WithTimeUnit WithQuantiles WithCompression
+func (m *MONITOR) RegisterTimer(name string, desc string, tags map[string]string,
+	opts ...MetricOption) (*MetricTimer, error) {
+
+	id, err := m.RegisterMetric(name, TimerMetric, desc, tags, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return m.timer(id), nil
+}
+
+// timer 返回已注册的耗时指标, 单位从指标配置中读取
+func (m *MONITOR) timer(id int) *MetricTimer {
+	unit := DefaultTimeUnit
+
+	m.Core.MetricMap.RLock()
+	if metric, ok := m.Core.MetricMap.Map[id]; ok {
+		unit, _ = metric.timeUnit()
+	}
+	m.Core.MetricMap.RUnlock()
+
+	return &MetricTimer{m: m, id: id, unit: unit}
+}
+
+// ID 返回耗时指标的 ID
+func (t *MetricTimer) ID() int {
+	return t.id
+}
+
+// Observe 记录一次耗时
+func (t *MetricTimer) Observe(d time.Duration) {
+	t.m.AddPersistent(t.id, TimerMetric, float64(d)/float64(t.unit))
+}
+
+// Since 记录从 start 开始到现在的耗时
+func (t *MetricTimer) Since(start time.Time) {
+	t.Observe(time.Since(start))
+}
+
+// Start 开始计时, 返回的函数结束计时并记录, 用法同 RecordFuncTimeAvg: defer t.Start()()
+func (t *MetricTimer) Start() (done func()) {
+	start := time.Now()
+	return func() {
+		t.Since(start)
+	}
+}
+
+// funcNames 调用位置 pc 到函数名的缓存, 同一调用位置只解析一次符号
+var funcNames sync.Map
+
+// callerFuncName 返回调用 callerFuncName 的函数的第 skip 层调用方的函数名, 0 为直接调用方
+// runtime.Callers 只获取 pc, 符号的解析结果按 pc 缓存
+func callerFuncName(skip int) string {
+	var pcs [1]uintptr
+	if runtime.Callers(skip+2, pcs[:]) == 0 {
+		return "unknown"
+	}
+	if name, ok := funcNames.Load(pcs[0]); ok {
+		return name.(string)
+	}
+
+	// CallersFrames 处理内联, 保证内联后的函数名正确
+	frame, _ := runtime.CallersFrames(pcs[:]).Next()
+	name := frame.Function
+	if name == "" {
+		name = "unknown"
+	}
+	funcNames.Store(pcs[0], name)
+	return name
+}
+
+// RecordFuncTimer 记录调用函数的耗时, 以函数名注册默认配置的耗时指标, 用法: defer m.RecordFuncTimer()()
+func (m *MONITOR) RecordFuncTimer() (done func()) {
+	return m.recordFuncTimer("RecordFuncTimer")
+}
+
+// recordFuncTimer 以调用 method 的函数名记录耗时, method 为调用方的方法名, 用于 recover 的日志
+func (m *MONITOR) recordFuncTimer(method string) (done func()) {
+	// 初始化过程中 panic 时返回空函数, 保证 defer 调用安全
+	done = nop
+	defer m.recoverRecord(method)
+
+	start := time.Now()
+	callFuncName := callerFuncName(2)
+
+	// 函数名已注册为其它类型时, 如同一函数还使用了 RecordFuncTimeAvg, 改用带后缀的名字
+	id, err := m.funcTimerID(callFuncName)
+	if _, ok := err.(*ErrMetricConflict); ok {
+		id, err = m.funcTimerID(callFuncName + FuncTimerSuffix)
+	}
+	if err != nil {
+		Logger.Warn("record func timer error", LogKeyMetric, callFuncName, LogKeyErr, err)
+		return
+	}
+	t := m.timer(id)
+
+	return func() {
+		t.Since(start)
+	}
+}
+
+// funcTimerID 返回以 name 注册的默认配置耗时指标的 ID, 未注册时注册
+func (m *MONITOR) funcTimerID(name string) (int, error) {
+	id, ok, err := m.lookupMetric(metricKey(name, nil), TimerMetric, nil)
+	if !ok {
+		id, err = m.initNewMetricName(name, TimerMetric, "Record a func timer metric", make(map[string]string))
+	}
+	return id, err
+}
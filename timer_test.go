@@ -0,0 +1,169 @@
+package monitor
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricTimer(t *testing.T) {
+	m, _ := New(NewConfig())
+	timer, err := m.RegisterTimer("db.query", "query latency", map[string]string{"db": "users"},
+		WithTimeUnit(time.Microsecond), WithQuantiles(0.5))
+	if err != nil {
+		t.Fatalf("register timer error %s", err)
+	}
+	if _, err := m.RegisterTimer("db.bad", "", nil, WithTimeUnit(time.Minute)); err == nil {
+		t.Fatal("expect time unit error")
+	}
+
+	for _, d := range []time.Duration{1 * time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond} {
+		timer.Observe(d)
+	}
+	omd := m.Core.NextMonitor()
+
+	metric := m.Core.MetricMap.Map[timer.ID()]
+	if want := []string{"_MinP50", "_Min", "_Max", "_Count", "_Sum", "_Avg"}; !reflect.DeepEqual(metric.Suffixes(), want) {
+		t.Fatalf("timer suffixes %v, want %v", metric.Suffixes(), want)
+	}
+	if got, want := getValues(metric, omd.PersistentData[timer.ID()]), []float64{2000, 1000, 3000, 3, 6000, 2000}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("timer values %v, want %v", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSONLines(&buf, collectPoints(m.Core.MetricMap, omd), omd.Ts.Unix()); err != nil {
+		t.Fatalf("json error %s", err)
+	}
+	if !strings.Contains(buf.String(), `"name":"db.query","tags":{"db":"users"},"type":12,"unit":"us"`) {
+		t.Fatalf("json output missing unit:\n%s", buf.String())
+	}
+
+	// 默认单位为毫秒, 没有记录时平均值为 NaN
+	def, _ := m.RegisterTimer("db.default", "", nil)
+	def.Observe(1500 * time.Microsecond)
+	omd = m.Core.NextMonitor()
+	values := getValues(m.Core.MetricMap.Map[def.ID()], omd.PersistentData[def.ID()])
+	if values[len(values)-1] != 1.5 || len(values) != len(TimerSuffix) {
+		t.Fatalf("default timer values %v", values)
+	}
+	values = getValues(metric, omd.PersistentData[timer.ID()])
+	if !math.IsNaN(values[len(values)-1]) {
+		t.Fatalf("empty timer avg %v, want NaN", values[len(values)-1])
+	}
+}
+
+func TestCallerFuncName(t *testing.T) {
+	name := callerFuncName(0)
+	if name != "monitor.TestCallerFuncName" {
+		t.Fatalf("caller func name %s", name)
+	}
+
+	// 同一调用位置第二次从缓存读取
+	var names []string
+	for i := 0; i < 2; i++ {
+		names = append(names, callerFuncName(0))
+	}
+	if names[0] != name || names[1] != name {
+		t.Fatalf("cached caller func names %v", names)
+	}
+}
+
+func recordFuncTimesTarget(m *MONITOR) {
+	defer m.RecordFuncTimes()()
+}
+
+func TestRecordFuncTimes(t *testing.T) {
+	m, _ := New(NewConfig())
+	for i := 0; i < 3; i++ {
+		recordFuncTimesTarget(m)
+	}
+	omd := m.Core.NextMonitor()
+
+	// 记录为耗时指标, _Count 为调用次数
+	id, ok := m.getCallNameMaeID("monitor.recordFuncTimesTarget")
+	if !ok {
+		t.Fatal("func timer not registered")
+	}
+	for _, p := range collectPoints(m.Core.MetricMap, omd) {
+		if p.Name == "monitor.recordFuncTimesTarget" {
+			if count, _ := p.Field("Count"); count != 3 || p.Type != TimerMetric || p.Unit != "ms" {
+				t.Fatalf("unexpected func timer point %+v", p)
+			}
+		}
+	}
+	if _, ok := omd.Data["monitor.recordFuncTimesTarget"]; ok {
+		t.Fatalf("func times should not add into plain data, id %d", id)
+	}
+}
+
+func recordFuncTimesAndAvgTarget(m *MONITOR) {
+	defer m.RecordFuncTimeAvg()()
+	defer m.RecordFuncTimes()()
+}
+
+func recordAvgAndFuncTimesTarget(m *MONITOR) {
+	defer m.RecordFuncTimes()()
+	defer m.RecordFuncTimeAvg()()
+}
+
+func TestRecordFuncTimesTypeConflict(t *testing.T) {
+	m, _ := New(NewConfig())
+	for i := 0; i < 2; i++ {
+		recordFuncTimesAndAvgTarget(m)
+		recordAvgAndFuncTimesTarget(m)
+	}
+	omd := m.Core.NextMonitor()
+
+	// 先注册的指标使用函数名, 后注册的使用带后缀的名字, 两个指标都记录, 也不会 panic
+	if got := omd.Data[SelfRecordPanics]; got != 0 {
+		t.Fatalf("record panics %v, want 0", got)
+	}
+	want := map[string]int{
+		"monitor.recordFuncTimesAndAvgTarget":                     AvgMetric,
+		"monitor.recordFuncTimesAndAvgTarget" + FuncTimerSuffix:   TimerMetric,
+		"monitor.recordAvgAndFuncTimesTarget":                     TimerMetric,
+		"monitor.recordAvgAndFuncTimesTarget" + FuncTimeAvgSuffix: AvgMetric,
+	}
+	for _, p := range collectPoints(m.Core.MetricMap, omd) {
+		typ, ok := want[p.Name]
+		if !ok {
+			continue
+		}
+		if p.Type != typ || p.Count != 2 {
+			t.Fatalf("unexpected point %+v", p)
+		}
+		delete(want, p.Name)
+	}
+	if len(want) > 0 {
+		t.Fatalf("missing points %v", want)
+	}
+}
+
+func TestRegisterTimerConflict(t *testing.T) {
+	m, _ := New(NewConfig())
+	if _, err := m.RegisterMetric("x", AvgMetric, "", nil); err != nil {
+		t.Fatalf("register error %s", err)
+	}
+	_, err := m.RegisterTimer("x", "", nil)
+	if _, ok := err.(*ErrMetricConflict); !ok {
+		t.Fatalf("register timer error %v, want ErrMetricConflict", err)
+	}
+
+	// 相同的类型及配置返回已有的 ID, 配置不同时返回错误
+	a, err := m.RegisterTimer("y", "", nil, WithTimeUnit(time.Second))
+	if err != nil {
+		t.Fatalf("register timer error %s", err)
+	}
+	if b, err := m.RegisterTimer("y", "", nil, WithTimeUnit(time.Second)); err != nil || b.ID() != a.ID() {
+		t.Fatalf("register same timer %v error %v", b, err)
+	}
+	if b, err := m.RegisterTimer("y", "", nil); err != nil || b.ID() != a.ID() {
+		t.Fatalf("register timer without options %v error %v", b, err)
+	}
+	if _, err := m.RegisterTimer("y", "", nil, WithTimeUnit(time.Millisecond)); err == nil {
+		t.Fatal("expect conflict for different time unit")
+	}
+}
@@ -0,0 +1,240 @@
+package monitor
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Top-K 指标, 使用 Space-Saving 算法在固定个数的计数器内记录出现最多的 key
+// 计数器已满时替换计数最小的 key, 新 key 的计数从被替换的计数开始, 被替换的计数即为其误差上界
+// 每个周期输出计数最多的 K 个 key, 每个 key 一个 Point, key 作为 TopKTag 的 tag 值
+
+const (
+	// DefaultTopK 默认输出的 key 个数
+	DefaultTopK = 10
+	// topKCapacityFactor 未配置计数器个数时为 K 的倍数, 越大越精确
+	topKCapacityFactor = 4
+	// TopKTag Top-K 指标输出时 key 的 tag 名
+	TopKTag = "key"
+)
+
+// TopKItem 一个 key 的计数
+type TopKItem struct {
+	Key   string  // key
+	Count float64 // 计数, 为真实计数的上界
+	Err   float64 // 误差上界, Count-Err 为真实计数的下界
+}
+
+// SpaceSaving Space-Saving 算法的计数器集合, 非并发安全, 由 SpecValue 的锁保护
+type SpaceSaving struct {
+	capacity int
+	items    map[string]*ssItem
+	heap     ssHeap
+}
+
+// ssItem 计数器, index 为在最小堆中的位置
+type ssItem struct {
+	TopKItem
+	index int
+}
+
+// ssHeap 按计数的最小堆, 计数相同时按 key 排序, 保证替换的 key 确定
+type ssHeap []*ssItem
+
+func (h ssHeap) Len() int { return len(h) }
+func (h ssHeap) Less(i, j int) bool {
+	if h[i].Count != h[j].Count {
+		return h[i].Count < h[j].Count
+	}
+	return h[i].Key > h[j].Key
+}
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *ssHeap) Push(x interface{}) {
+	item := x.(*ssItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *ssHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// NewSpaceSaving 返回最多记录 capacity 个 key 的计数器集合
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	return &SpaceSaving{
+		capacity: capacity,
+		items:    make(map[string]*ssItem, capacity),
+		heap:     make(ssHeap, 0, capacity),
+	}
+}
+
+// Add 将 key 的计数增加 weight
+func (s *SpaceSaving) Add(key string, weight float64) {
+	if item, ok := s.items[key]; ok {
+		item.Count += weight
+		heap.Fix(&s.heap, item.index)
+		return
+	}
+
+	if len(s.heap) < s.capacity {
+		item := &ssItem{TopKItem: TopKItem{Key: key, Count: weight}}
+		s.items[key] = item
+		heap.Push(&s.heap, item)
+		return
+	}
+
+	// 替换计数最小的 key
+	min := s.heap[0]
+	delete(s.items, min.Key)
+	min.Key, min.Err = key, min.Count
+	min.Count += weight
+	s.items[key] = min
+	heap.Fix(&s.heap, 0)
+}
+
+// Top 返回计数最多的 k 个 key, 按计数从大到小排序, 计数相同时按 key 排序
+func (s *SpaceSaving) Top(k int) []TopKItem {
+	items := make([]TopKItem, 0, len(s.heap))
+	for _, item := range s.heap {
+		items = append(items, item.TopKItem)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Key < items[j].Key
+	})
+	if len(items) > k {
+		items = items[:k]
+	}
+	return items
+}
+
+// WithTopK Top-K 指标每个周期输出的 key 个数, 需 >= 1, 未配置时为 DefaultTopK
+func WithTopK(k int) MetricOption {
+	return func(m *MetricName) error {
+		if m.Type != TopKMetric {
+			return &ErrMetricOption{Name: m.Name, Msg: "top k only for TopKMetric"}
+		}
+		if k < 1 {
+			return &ErrMetricOption{Name: m.Name, Msg: "top k must >= 1"}
+		}
+		m.TopK = k
+		return nil
+	}
+}
+
+// WithTopKCapacity Top-K 指标的计数器个数, 决定内存占用及精度, 小于 K 时使用 K
+// 未配置时为 K 的 topKCapacityFactor 倍
+func WithTopKCapacity(capacity int) MetricOption {
+	return func(m *MetricName) error {
+		if m.Type != TopKMetric {
+			return &ErrMetricOption{Name: m.Name, Msg: "top k capacity only for TopKMetric"}
+		}
+		if capacity < 1 {
+			return &ErrMetricOption{Name: m.Name, Msg: "top k capacity must >= 1"}
+		}
+		m.TopKCapacity = capacity
+		return nil
+	}
+}
+
+// topK 返回输出的 key 个数及计数器个数
+func (m *MetricName) topK() (k, capacity int) {
+	k = m.TopK
+	if k == 0 {
+		k = DefaultTopK
+	}
+	capacity = m.TopKCapacity
+	if capacity == 0 {
+		capacity = k * topKCapacityFactor
+	}
+	if capacity < k {
+		capacity = k
+	}
+	return
+}
+
+// newTopKSpecValue 返回 Top-K 类型的特殊数据
+func newTopKSpecValue(metric *MetricName) *SpecValue {
+	_, capacity := metric.topK()
+	return &SpecValue{TopK: NewSpaceSaving(capacity)}
+}
+
+// topKPoints 将 Top-K 指标展开为每个 key 一个 Point, 值与 TopKSuffix 一一对应
+func topKPoints(metric *MetricName, SPV *SpecValue) []*Point {
+	k, _ := metric.topK()
+	items := SPV.TopK.Top(k)
+
+	points := make([]*Point, 0, len(items))
+	for _, item := range items {
+		tags := make(map[string]string, len(metric.Tags)+1)
+		for name, value := range metric.Tags {
+			tags[name] = value
+		}
+		tags[TopKTag] = item.Key
+
+		points = append(points, &Point{
+			Name:     metric.Name,
+			Tags:     tags,
+			Describe: metric.Describe,
+			Type:     metric.Type,
+			Suffix:   metric.Suffixes(),
+			Values:   []float64{item.Count, item.Err},
+			Sum:      item.Count,
+			Count:    SPV.Count,
+		})
+	}
+	return points
+}
+
+// AddTopK 将 Top-K 指标中 key 的计数增加 weight, weight 需 > 0
+// weight 不合法时同指标 ID 不存在一样丢弃并计入 SelfDroppedRecords
+func (oms *OneMinStorage) AddTopK(MapID int, key string, weight float64) {
+	oms.Lock()
+	defer oms.Unlock()
+
+	if !(weight > 0) {
+		oms.Data[SelfDroppedRecords]++
+		Logger.Warn("add top k weight must > 0", LogKeyID, MapID, LogKeyTs, oms.Ts.Unix(), "weight", weight)
+		return
+	}
+
+	if sv, ok := oms.PersistentData[MapID]; ok && sv.TopK != nil {
+		sv.Lock()
+		sv.TopK.Add(key, weight)
+		sv.Sum += weight
+		sv.Count++
+		sv.Unlock()
+		return
+	}
+
+	oms.Data[SelfDroppedRecords]++
+	Logger.Warn("add top k not have map id", LogKeyID, MapID, LogKeyTs, oms.Ts.Unix())
+}
+
+// AddTopK 将 Top-K 指标中 key 的计数增加 weight, 如出错的客户 ID 记录 1, weight 需 > 0
+func (m *MONITOR) AddTopK(MapID int, key string, weight float64) {
+	defer m.recoverRecord("AddTopK")
+	m.Core.RLock()
+	defer m.Core.RUnlock()
+	m.Core.NowMonitor.AddTopK(MapID, key, weight)
+}
+
+// topKString 返回 Top-K 的文本表示, 用于 HTTP 展示
+func topKString(s *SpaceSaving) string {
+	var buf bytes.Buffer
+	for i, item := range s.Top(s.capacity) {
+		fmt.Fprintf(&buf, "\t%d. %s: %s (err %s)\n", i+1, item.Key,
+			strconv.FormatFloat(item.Count, 'g', -1, 64), strconv.FormatFloat(item.Err, 'g', -1, 64))
+	}
+	return buf.String()
+}
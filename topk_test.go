@@ -0,0 +1,100 @@
+package monitor
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	s := NewSpaceSaving(3)
+	s.Add("a", 5)
+	s.Add("b", 3)
+	s.Add("c", 1)
+	s.Add("a", 1)
+
+	// 已满, d 替换计数最小的 c, 误差为 c 的计数
+	s.Add("d", 1)
+	want := []TopKItem{{Key: "a", Count: 6}, {Key: "b", Count: 3}, {Key: "d", Count: 2, Err: 1}}
+	if got := s.Top(5); !reflect.DeepEqual(got, want) {
+		t.Fatalf("top %+v, want %+v", got, want)
+	}
+	if got := s.Top(1); len(got) != 1 || got[0].Key != "a" {
+		t.Fatalf("top 1 %+v", got)
+	}
+}
+
+func TestSpaceSavingHeavyHitters(t *testing.T) {
+	s := NewSpaceSaving(20)
+	// 大量只出现一次的 key 中混入几个高频 key, 内存始终不超过 20 个计数器
+	// 出现次数超过 总数/20 的 key 一定被记录
+	for i := 0; i < 10000; i++ {
+		s.Add("noise-"+strconv.Itoa(i), 1)
+		if i%5 == 0 {
+			s.Add("hot-1", 1)
+		}
+		if i%10 == 0 {
+			s.Add("hot-2", 1)
+		}
+	}
+	if len(s.items) != 20 {
+		t.Fatalf("items %d, want 20", len(s.items))
+	}
+
+	top := s.Top(2)
+	if top[0].Key != "hot-1" || top[1].Key != "hot-2" {
+		t.Fatalf("top %+v", top)
+	}
+	for _, item := range top {
+		if want := map[string]float64{"hot-1": 2000, "hot-2": 1000}[item.Key]; item.Count < want || item.Count-item.Err > want {
+			t.Fatalf("lower bound %v exceeds real count", item)
+		}
+	}
+}
+
+func TestTopKMetric(t *testing.T) {
+	m, _ := New(NewConfig())
+	id, err := m.RegisterMetric("errors.customer", TopKMetric, "errors by customer",
+		map[string]string{"api": "pay"}, WithTopK(2))
+	if err != nil {
+		t.Fatalf("register error %s", err)
+	}
+	if _, err := m.RegisterMetric("errors.total", TopKMetric, "", nil, WithCumulative()); err == nil {
+		t.Fatal("expect cumulative option error for TopKMetric")
+	}
+	if _, err := m.RegisterMetric("errors.key", TopKMetric, "", map[string]string{TopKTag: "x"}); err == nil {
+		t.Fatal("expect reserved tag error for TopKMetric")
+	}
+
+	m.AddTopK(id, "c1", 1)
+	m.AddTopK(id, "c2", 5)
+	m.AddTopK(id, "c3", 2)
+	m.AddPersistent(id, TopKMetric, 42)
+	// 不合法的 weight 丢弃, 不影响计数
+	m.AddTopK(id, "c1", 0)
+	m.AddTopK(id, "c3", -10)
+	omd := m.Core.NextMonitor()
+	if got := omd.Data[SelfDroppedRecords]; got != 2 {
+		t.Fatalf("dropped records %v, want 2", got)
+	}
+
+	var got []string
+	for _, p := range collectPoints(m.Core.MetricMap, omd) {
+		if p.Name == "errors.customer" {
+			got = append(got, p.Tags[TopKTag]+"="+strconv.FormatFloat(p.Values[0], 'g', -1, 64))
+			if p.Tags["api"] != "pay" || !reflect.DeepEqual(p.Suffix, TopKSuffix) {
+				t.Fatalf("unexpected point %+v", p)
+			}
+		}
+	}
+	// 按 key 排序输出
+	if want := []string{"c2=5", "c3=2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("top k points %v, want %v", got, want)
+	}
+
+	s := formatString(t, TextFormat, NewSnapshot(m.Core.MetricMap, omd))
+	if !strings.Contains(s, "errors.customer_TopCount;api=pay;key=c2=5\n") {
+		t.Fatalf("text output missing top k line:\n%s", s)
+	}
+}
@@ -0,0 +1,126 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Transport 负责将 Formatter 格式化后的数据送达, 如落地文件, tcp, udp 及 http 上传
+
+// Transport 数据发送接口
+type Transport interface {
+	Send(data []byte, contentType string) error // 发送一个周期格式化后的数据
+}
+
+// RegisterTransportName 存储已注册的 Transport Name
+var RegisterTransportName = map[string]func(conf *WriterConfig) Transport{}
+
+const (
+	// FileTransport 落地为本地文件, 文件为 WriterConfig 的 DownPath
+	FileTransport = "file"
+
+	// transportTimeout tcp 及 http 的超时时间
+	transportTimeout = 10 * time.Second
+	// transportUDPPayload udp 单个包的最大长度, 按行切分
+	transportUDPPayload = 1400
+)
+
+// init 注册 file tcp udp http 四种 Transport
+func init() {
+	RegisterTransportName[FileTransport] = func(conf *WriterConfig) Transport {
+		return &fileTransport{path: conf.DownPath, perm: fileMode(conf)}
+	}
+	RegisterTransportName[TCPProtocol] = func(conf *WriterConfig) Transport {
+		return &tcpTransport{addr: uploadAddr(conf)}
+	}
+	RegisterTransportName[UDPProtocol] = func(conf *WriterConfig) Transport {
+		return &udpTransport{addr: uploadAddr(conf)}
+	}
+	RegisterTransportName[HTTPProtocol] = func(conf *WriterConfig) Transport {
+		path := conf.UploadPath
+		if path == "" {
+			path = "/"
+		}
+		return &httpTransport{
+			url:    "http://" + uploadAddr(conf) + path,
+			client: &http.Client{Timeout: transportTimeout},
+		}
+	}
+}
+
+// InitTransport 根据名字初始化一个 Transport
+func InitTransport(name string, conf *WriterConfig) (Transport, error) {
+	if f, ok := RegisterTransportName[name]; ok {
+		return f(conf), nil
+	}
+	return nil, &ErrorWriterConfig{Msg: fmt.Sprintf("Can't find Transport %s", name)}
+}
+
+// uploadAddr 返回配置的上传地址 host:port
+func uploadAddr(conf *WriterConfig) string {
+	return net.JoinHostPort(conf.UpLoadHost, strconv.Itoa(conf.UpLoadPort))
+}
+
+// fileTransport 原子地替换文件, 文件内容始终为最后一个周期的数据
+type fileTransport struct {
+	path string
+	perm os.FileMode
+}
+
+// Send 实现 Transport 接口
+func (t *fileTransport) Send(data []byte, contentType string) error {
+	return writeFileAtomic(t.path, data, t.perm)
+}
+
+// tcpTransport 每个周期建立一次 tcp 连接发送
+type tcpTransport struct {
+	addr string
+}
+
+// Send 实现 Transport 接口
+func (t *tcpTransport) Send(data []byte, contentType string) error {
+	conn, err := net.DialTimeout(TCPProtocol, t.addr, transportTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(transportTimeout))
+	_, err = conn.Write(data)
+	return err
+}
+
+// udpTransport 按行切分为多个 udp 包发送
+type udpTransport struct {
+	addr string
+}
+
+// Send 实现 Transport 接口
+func (t *udpTransport) Send(data []byte, contentType string) error {
+	return sendUDPLines(t.addr, data, transportUDPPayload)
+}
+
+// httpTransport 通过 http POST 上传, 非 2xx 的响应为失败
+type httpTransport struct {
+	url    string
+	client *http.Client
+}
+
+// Send 实现 Transport 接口
+func (t *httpTransport) Send(data []byte, contentType string) error {
+	resp, err := t.client.Post(t.url, contentType, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("http transport status %s", resp.Status)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package monitor
 
 import (
+	"bytes"
 	"fmt"
 	"math"
 	"net/http"
@@ -10,7 +11,7 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// 完整已有指标访问方式, 应该需要格式化, 可采用 writer中的方式格式化后输出 or 其它
+// 完整已有指标访问方式, /metrics 可通过 format 参数使用任意已注册的 Formatter 格式化后输出
 
 var (
 	// HostName 主机名
@@ -37,6 +38,7 @@ func (m *MONITOR) StartHTTPModule(port int) error {
 	ListenPortStr := ":" + strconv.Itoa(port)
 
 	r := mux.NewRouter()
+	r.Use(m.selfHTTPMiddleware)
 	r.HandleFunc("/", Welcome) //设置访问的路由
 
 	r.HandleFunc("/last", m.HandleCurrent).Methods("GET") // 设置访问的路由
@@ -45,14 +47,16 @@ func (m *MONITOR) StartHTTPModule(port int) error {
 
 	r.HandleFunc("/history/{HVersion}/{metric}", m.HandleHistory).Methods("GET") //设置访问的路由
 
+	r.HandleFunc("/metrics", m.HandleFormat).Methods("GET") // 按 format 参数格式化输出
+
 	go func() {
 		err := http.ListenAndServe(ListenPortStr, r) //设置监听的IP和端口
 		if err != nil {
-			Logger.Printf("Start Http Module Error %s", err)
+			Logger.Error("start http module error", "port", port, LogKeyErr, err)
 		}
 	}()
 
-	Logger.Println("Start Monitor Http Module Done.")
+	Logger.Info("start monitor http module done", "port", port)
 
 	return nil
 }
@@ -80,7 +84,7 @@ func (m *MONITOR) HandleMonitor(w http.ResponseWriter, r *http.Request) {
 
 	// 输出普通数据中的key
 	if v, ok := m.Core.NowMonitor.Data[k]; ok {
-		fmt.Fprintf(w, fmt.Sprintf("%s : %f", k, v))
+		fmt.Fprintf(w, "%s : %s", k, formatFloat(v, m.Conf.Precision))
 	}
 
 	// 加锁
@@ -91,7 +95,7 @@ func (m *MONITOR) HandleMonitor(w http.ResponseWriter, r *http.Request) {
 	if intK, ok := m.Core.MetricMap.CallNameMap[k]; ok {
 		name := m.Core.MetricMap.Map[intK]
 		if v, ok := m.Core.NowMonitor.PersistentData[intK]; ok {
-			fmt.Fprintf(w, fmt.Sprintf("Key: %s\n%s%s", k, name.String(), v.String()))
+			fmt.Fprintf(w, "Key: %s\n%s%s", k, name.String(), v.format(m.Conf.Precision))
 		}
 	}
 }
@@ -109,14 +113,7 @@ func (m *MONITOR) HandleHistory(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 获取历史版本的一分钟数据
-	m.Core.RLock()
-	cur := m.Core.Cursor
-	idx := cur - int(math.Abs(float64(hv)))
-	for idx < 0 {
-		idx = idx + m.Core.HistoryVersionNumber
-	}
-	hd := m.Core.HistoryMonitor[idx]
-	m.Core.RUnlock()
+	hd := m.Core.History(int(math.Abs(float64(hv))))
 	if hd == nil {
 		return
 	}
@@ -131,7 +128,7 @@ func (m *MONITOR) HandleHistory(w http.ResponseWriter, r *http.Request) {
 
 	// 返回普通数据中的key
 	if v, ok := hd.Data[k]; ok {
-		fmt.Fprintf(w, fmt.Sprintf("%s : %f", k, v))
+		fmt.Fprintf(w, "%s : %s", k, formatFloat(v, m.Conf.Precision))
 	}
 
 	// 加锁
@@ -142,7 +139,7 @@ func (m *MONITOR) HandleHistory(w http.ResponseWriter, r *http.Request) {
 	if intK, ok := m.Core.MetricMap.CallNameMap[k]; ok {
 		name := m.Core.MetricMap.Map[intK]
 		if v, ok := hd.PersistentData[intK]; ok {
-			fmt.Fprintf(w, fmt.Sprintf("Key: %s\n%s%s", k, name, v))
+			fmt.Fprintf(w, "Key: %s\n%s%s", k, name, v.format(m.Conf.Precision))
 		}
 	}
 }
@@ -153,5 +150,62 @@ func (m *MONITOR) HandleHistory(w http.ResponseWriter, r *http.Request) {
 func (m *MONITOR) HandleCurrent(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Connection", "close")
 	http.ServeFile(w, r, m.Conf.WebPath)
-	Logger.Printf("Get Current file [%s]", m.Conf.WebPath)
+	Logger.Debug("get current file", "path", m.Conf.WebPath)
+}
+
+// HandleFormat 使用已注册的 Formatter 输出一个周期的全部数据
+// format 参数为 Formatter 名, 默认为 text
+// version 参数为 current 时输出当前周期, 为数字 n 时输出第 n 个历史版本, 默认为最后一个已完成的周期
+func (m *MONITOR) HandleFormat(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Connection", "close")
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = TextFormat
+	}
+	conf := m.httpWriterConfig()
+	formatter, err := InitFormatter(format, conf)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var omd *OneMinStorage
+	switch v := query.Get("version"); v {
+	case "current":
+		m.Core.RLock()
+		omd = m.Core.NowMonitor
+		m.Core.RUnlock()
+	case "":
+		omd = m.Core.History(1)
+	default:
+		hv, err := strconv.Atoi(v)
+		if err != nil || hv <= 0 || hv > m.Core.HistoryVersionNumber {
+			http.Error(w, "History Version Type Error", http.StatusBadRequest)
+			return
+		}
+		omd = m.Core.History(hv)
+	}
+	if omd == nil {
+		http.Error(w, "No Data", http.StatusNotFound)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = formatter.Format(&buf, NewSnapshot(m.Core.MetricMap, omd).Round(conf.Precision)); err != nil {
+		Logger.Error("http format error", "format", format, LogKeyErr, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", formatter.ContentType())
+	w.Write(buf.Bytes())
+}
+
+// httpWriterConfig 返回 http 模块格式化使用的配置, 精度为 Config 中的 Precision
+func (m *MONITOR) httpWriterConfig() *WriterConfig {
+	conf := NewWriterConfig()
+	conf.Precision = m.Conf.Precision
+	return conf
 }
@@ -1,20 +1,20 @@
 package monitor
 
 import (
-	"io/ioutil"
+	"math"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Writer 应当可以从错误中恢复, 不应该对监控系统 甚至业务产生影响
 // Writer 应当负责格式化数据并以对应的方式最终处理
-// 如: 格式化为 普罗米修斯 的方式,上传 or 落地 及 其它 ?
-// 如: 格式化为 Txt 的方式,上传 or 落地 及 其它 ?
+// 格式化与发送可拆分为 Formatter 和 Transport, 由 FormatWriter 按配置组合
+// 如: 格式化为 普罗米修斯 的方式, 通过 http 上传 or 落地为文件
 type Writer interface {
 	DoWithRecover(nameMap *MetricNameMap, omd *OneMinStorage) error // 数据写入方法
-	// ISMe(name string) bool                                      // 应当具有一个指标名, 可判断是否进行http格式化
-	// HTTPFormat(omd *OneMinStorage, name string) ([]byte, error) // HTTP 方式格式化数据,返回 []byte, 不执行其它处理 ???
 }
 
 const (
@@ -38,6 +38,28 @@ const (
 
 	// UPloadRetryError 常量字符串, 描述正确的上传重试次数
 	UPloadRetryError = `Upload Retry in 0-10, if n>10 please use force=true argument.`
+
+	// HTTPProtocol 通过 http 上传
+	HTTPProtocol = "http"
+	// UDPProtocol 通过 udp 上传
+	UDPProtocol = "udp"
+	// TCPProtocol 通过 tcp 上传
+	TCPProtocol = "tcp"
+
+	// ProtocolError 常量字符串, 描述正确的上传协议
+	ProtocolError = `protocol must in ("http", "udp", "tcp") not case sensitive`
+)
+
+const (
+	// FullPrecision 不对输出值取整, 以不带指数的最短完整精度输出
+	FullPrecision = -1
+	// DefaultPrecision 默认精度, 为 FullPrecision
+	DefaultPrecision = FullPrecision
+	// maxPrecision float64 有效的最大小数位数
+	maxPrecision = 15
+
+	// PrecisionError 常量字符串, 描述合法的精度
+	PrecisionError = "Precision must in 0-15, or FullPrecision (-1) for no rounding"
 )
 
 var (
@@ -63,18 +85,52 @@ var (
 	// CountAvgSuffix Count 和 Avg 结尾的后缀
 	CountAvgSuffix = []string{"_Count", "_Avg"}
 
-	// QuantileSuffix 分位数后缀
-	QuantileSuffix = []string{"_MinP50", "_MinP90", "_MinP95", "_MinP99"}
+	// QuantileSuffix 分位数后缀, 默认分位点之后为 最小值 最大值 计数 总和
+	// 通过 WithQuantiles 配置了分位点的指标, 后缀根据分位点生成, 参考 MetricName.Suffixes
+	QuantileSuffix = []string{"_MinP50", "_MinP90", "_MinP95", "_MinP99", "_Min", "_Max", "_Count", "_Sum"}
+
+	// defaultQuantiles 分位数类型默认输出的分位点, 与 QuantileSuffix 的前 4 个一一对应
+	defaultQuantiles = []float64{0.50, 0.90, 0.95, 0.99}
+
+	// GaugeSuffix gauge 类型的后缀
+	GaugeSuffix = []string{"_Last", "_Min", "_Max", "_Mean"}
+
+	// RateSuffix 速率类型的后缀, 每秒速率及 1/5/15 个周期的 EWMA
+	RateSuffix = []string{"_Rate", "_Rate1", "_Rate5", "_Rate15"}
+
+	// CardinalitySuffix 去重计数类型的后缀, 估计值及其标准误差
+	CardinalitySuffix = []string{"_Distinct", "_DistinctErr"}
+
+	// TopKSuffix Top-K 类型每个 key 的后缀, 计数及误差上界
+	TopKSuffix = []string{"_TopCount", "_TopErr"}
+
+	// TimerSuffix 耗时类型的后缀, 默认分位点之后为 最小值 最大值 计数 总和 平均值
+	TimerSuffix = append(QuantileSuffix[:len(QuantileSuffix):len(QuantileSuffix)], timerStatSuffix...)
+
+	// timerStatSuffix 耗时类型在分位数类型之后增加的后缀
+	timerStatSuffix = []string{"_Avg"}
+
+	// CumulativeSuffix 配置了 WithCumulative 的指标在最后输出的累计值后缀
+	CumulativeSuffix = []string{"_CountTotal", "_SumTotal"}
+
+	// quantileStatSuffix 分位数类型在分位点之后输出的后缀
+	quantileStatSuffix = []string{"_Min", "_Max", "_Count", "_Sum"}
 
 	// SuffixMap 结尾映射
 	SuffixMap = map[int][]string{
-		BaseMetric:     BaseSuffix,
-		SumMetric:      SumSuffix,
-		AvgMetric:      AvgSuffix,
-		CountMetric:    CountSuffix,
-		CountSumMetric: CountSumSuffix,
-		CountAvgMetric: CountAvgSuffix,
-		QuantileMetric: QuantileSuffix,
+		BaseMetric:        BaseSuffix,
+		SumMetric:         SumSuffix,
+		AvgMetric:         AvgSuffix,
+		CountMetric:       CountSuffix,
+		CountSumMetric:    CountSumSuffix,
+		CountAvgMetric:    CountAvgSuffix,
+		QuantileMetric:    QuantileSuffix,
+		HistogramMetric:   HistogramSuffix,
+		GaugeMetric:       GaugeSuffix,
+		RateMetric:        RateSuffix,
+		CardinalityMetric: CardinalitySuffix,
+		TopKMetric:        TopKSuffix,
+		TimerMetric:       TimerSuffix,
 	}
 )
 
@@ -94,12 +150,25 @@ func (e *ErrorWriterConfig) Error() string {
 
 // WriterConfig 一个 Writer 的配置信息
 type WriterConfig struct {
-	Name        string // 定义一个名字, 用于 http 格式化的时候是否需要自己进行
-	Mode        int    // 工作模式
-	UpLoadHost  string // 上传的主机地址 ip or 主机名
-	UpLoadPort  int    // 上传的主机的端口
-	UploadRetry int    // 上传失败重试次数
-	DownPath    string // 落地文件的路径及名字, 需要注意冲突及权限, 建议相对路径
+	Name        string      // 定义一个名字, 用于 http 格式化的时候是否需要自己进行
+	Mode        int         // 工作模式
+	UpLoadHost  string      // 上传的主机地址 ip or 主机名
+	UpLoadPort  int         // 上传的主机的端口
+	UploadRetry int         // 上传失败重试次数
+	DownPath    string      // 落地文件的路径及名字, 需要注意冲突及权限, 建议相对路径
+	FileMode    os.FileMode // 落地文件的权限, 为 0 时使用 DefaultFileMode
+	Protocol    string      // 上传的协议 http, udp or tcp, 为空时使用 Writer 的默认协议
+	UploadPath  string      // http 上传的路径及参数, 如 /write?db=monitor
+	BatchSize   int         // 每批上传的数据点个数, 为 0 时使用 Writer 的默认值
+	BatchBytes  int         // 每批上传的最大字节数, 为 0 时使用 Writer 的默认值
+	Format      string      // 格式化方式, 参考 RegisterFormatterName, 为空时使用 Writer 的默认格式
+	Transport   string      // 发送方式, 参考 RegisterTransportName, 为空时使用 Writer 的默认方式
+	Precision   int         // 输出值保留的小数位数, 0 为取整到整数, FullPrecision 为不取整
+
+	// 追加写入的文件 Writer 使用, 如 JSONLinesWriter CSVWriter
+	MaxFileSize    int64         // 单个文件的最大字节数, 超过后轮转, 0 为不按大小轮转
+	RotateInterval time.Duration // 按时间轮转的周期, 0 为不按时间轮转
+	MaxBackups     int           // 保留的轮转后的压缩文件个数, 0 为全部保留
 }
 
 // NewWriterConfig 返回一个默认的 writer 配置
@@ -113,6 +182,8 @@ func NewWriterConfig() *WriterConfig {
 		UpLoadPort:  2003,
 		UploadRetry: 0,
 		DownPath:    "./go-monitor.txt",
+		FileMode:    DefaultFileMode,
+		Precision:   DefaultPrecision,
 	}
 }
 
@@ -200,49 +271,245 @@ func (w *WriterConfig) ValidateUploadRetry(n int, force bool) error {
 	return nil
 }
 
+// ValidateProtocol 上传协议校验及配置, 不区分大小写
+func (w *WriterConfig) ValidateProtocol(protocol string) error {
+	switch p := strings.ToLower(protocol); p {
+	case HTTPProtocol, UDPProtocol, TCPProtocol:
+		w.Protocol = p
+		return nil
+	}
+	return &ErrorWriterConfig{Msg: ProtocolError}
+}
+
+// ValidateUploadPath http 上传路径校验及配置, 需以 / 开头
+func (w *WriterConfig) ValidateUploadPath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return &ErrorWriterConfig{Msg: "Upload Path must start with /"}
+	}
+	w.UploadPath = path
+	return nil
+}
+
+// ValidateFormat 格式化方式校验及配置, 参考 RegisterFormatterName
+func (w *WriterConfig) ValidateFormat(format string) error {
+	if _, ok := RegisterFormatterName[format]; !ok {
+		return &ErrorWriterConfig{Msg: "Can't find This Formatter"}
+	}
+	w.Format = format
+	return nil
+}
+
+// ValidateTransport 发送方式校验及配置, 参考 RegisterTransportName
+func (w *WriterConfig) ValidateTransport(transport string) error {
+	if _, ok := RegisterTransportName[transport]; !ok {
+		return &ErrorWriterConfig{Msg: "Can't find This Transport"}
+	}
+	w.Transport = transport
+	return nil
+}
+
+// ValidateBatchSize 每批上传的数据点个数校验, 0 为使用 Writer 的默认值
+func (w *WriterConfig) ValidateBatchSize(n int) error {
+	if n < 0 {
+		return &ErrorWriterConfig{Msg: "Batch Size must be >= 0"}
+	}
+	w.BatchSize = n
+	return nil
+}
+
+// ValidateBatchBytes 每批上传的最大字节数校验, 0 为使用 Writer 的默认值
+func (w *WriterConfig) ValidateBatchBytes(n int) error {
+	if n < 0 {
+		return &ErrorWriterConfig{Msg: "Batch Bytes must be >= 0"}
+	}
+	w.BatchBytes = n
+	return nil
+}
+
+// ValidateRotate 文件轮转的配置校验
+// maxSize 为单个文件的最大字节数, every 为按时间轮转的周期, backups 为保留的压缩文件个数
+// 均为 0 时不轮转
+func (w *WriterConfig) ValidateRotate(maxSize int64, every time.Duration, backups int) error {
+	if maxSize < 0 || every < 0 || backups < 0 {
+		return &ErrorWriterConfig{Msg: "Rotate size, interval and backups must be >= 0"}
+	}
+	if every > 0 && every < time.Second {
+		return &ErrorWriterConfig{Msg: "Rotate interval must >= 1s"}
+	}
+
+	w.MaxFileSize = maxSize
+	w.RotateInterval = every
+	w.MaxBackups = backups
+	return nil
+}
+
+// ValidatePrecision 输出值小数位数校验及配置, 0 为取整到整数, FullPrecision 为不取整
+func (w *WriterConfig) ValidatePrecision(precision int) error {
+	if !validPrecision(precision) {
+		return &ErrorWriterConfig{Msg: PrecisionError}
+	}
+	w.Precision = precision
+	return nil
+}
+
+// validPrecision 判断精度是否合法, Config 与 WriterConfig 共用
+func validPrecision(precision int) bool {
+	return precision == FullPrecision || (precision >= 0 && precision <= maxPrecision)
+}
+
+// ValidateFileMode 落地文件权限校验及配置, 只允许权限位
+func (w *WriterConfig) ValidateFileMode(mode os.FileMode) error {
+	if mode == 0 || mode&^os.ModePerm != 0 {
+		return &ErrorWriterConfig{Msg: "File Mode must in 0001-0777"}
+	}
+	w.FileMode = mode
+	return nil
+}
+
 // ValidateDownPath 模式配置校验及配置
 func (w *WriterConfig) ValidateDownPath(path string) error {
 	// 可能需要其它校验逻辑,暂未想到很多  不能为空???  TODO
 	return nil
 }
 
-// getValueString 格式化 特殊监控的 value 值, 并返回
-// 本方法不支持格式化分位数的值, 原因在于分位数可能需要返回多个
-func getValueString(_type int, SPV *SpecValue) []string {
-	switch _type {
-	case BaseMetric:
-		return []string{strconv.FormatFloat(SPV.Sum, 'f', 5, 64)}
-	case SumMetric:
-		return []string{strconv.FormatFloat(SPV.Sum, 'f', 5, 64)}
+// formatFloat 格式化数值, precision 为小数位数, FullPrecision 时为最短的完整精度表示, 均不使用指数
+func formatFloat(value float64, precision int) string {
+	if precision < 0 {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(value, 'f', precision, 64)
+}
+
+// getValues 返回特殊监控的 value 值, 与 metric.Suffixes() 中的后缀一一对应
+func getValues(metric *MetricName, SPV *SpecValue) []float64 {
+	values := intervalValues(metric, SPV)
+	if metric.Cumulative {
+		values = append(values, float64(SPV.TotalCount+SPV.Count), SPV.TotalSum+SPV.Sum)
+	}
+	return values
+}
+
+// intervalValues 返回一个周期内的增量值, 与 SuffixMap 或配置生成的后缀一一对应
+func intervalValues(metric *MetricName, SPV *SpecValue) []float64 {
+	switch metric.Type {
+	case BaseMetric, SumMetric:
+		return []float64{SPV.Sum}
 	case AvgMetric:
-		return []string{strconv.FormatFloat(SPV.Sum/float64(SPV.Count), 'f', 5, 64)}
+		return []float64{SPV.Sum / float64(SPV.Count)}
 	case CountMetric:
-		return []string{strconv.FormatInt(SPV.Count, 10)}
+		return []float64{float64(SPV.Count)}
 	case CountSumMetric:
-		return []string{
-			strconv.FormatInt(SPV.Count, 10),
-			strconv.FormatFloat(SPV.Sum, 'f', 5, 64),
-		}
+		return []float64{float64(SPV.Count), SPV.Sum}
 	case CountAvgMetric:
-		return []string{
-			strconv.FormatInt(SPV.Count, 10),
-			strconv.FormatFloat(SPV.Sum/float64(SPV.Count), 'f', 5, 64),
-		}
+		return []float64{float64(SPV.Count), SPV.Sum / float64(SPV.Count)}
 	case QuantileMetric:
-		return []string{
-			strconv.FormatFloat(SPV.Otd.Quantile(0.50), 'f', 5, 64),
-			strconv.FormatFloat(SPV.Otd.Quantile(0.90), 'f', 5, 64),
-			strconv.FormatFloat(SPV.Otd.Quantile(0.95), 'f', 5, 64),
-			strconv.FormatFloat(SPV.Otd.Quantile(0.99), 'f', 5, 64),
+		return quantileValues(metric, SPV)
+	case TimerMetric:
+		return append(quantileValues(metric, SPV), SPV.Sum/float64(SPV.Count))
+	case HistogramMetric:
+		return histogramValues(SPV)
+	case GaugeMetric:
+		// 没有取值时均为 NaN, 本周期没有取值但延续了上一个周期的最后值时均为最后值
+		if SPV.Count == 0 && !SPV.HasLast {
+			return []float64{math.NaN(), math.NaN(), math.NaN(), math.NaN()}
+		}
+		if SPV.Count == 0 {
+			return []float64{SPV.Last, SPV.Last, SPV.Last, SPV.Last}
+		}
+		return []float64{SPV.Last, SPV.Min, SPV.Max, SPV.Sum / float64(SPV.Count)}
+	case RateMetric:
+		return []float64{SPV.Rate, SPV.EWMA[0], SPV.EWMA[1], SPV.EWMA[2]}
+	case CardinalityMetric:
+		estimate := SPV.HLL.Estimate()
+		return []float64{estimate, estimate * SPV.HLL.StdError()}
+	}
+
+	return []float64{}
+}
+
+// quantileValues 返回分位数类型的各分位点及 最小值 最大值 计数 总和
+func quantileValues(metric *MetricName, SPV *SpecValue) []float64 {
+	quantiles := metric.quantiles()
+	values := make([]float64, 0, len(quantiles)+len(quantileStatSuffix))
+	for _, q := range quantiles {
+		values = append(values, SPV.Otd.Quantile(q))
+	}
+
+	// 没有数据时最小值 最大值为 NaN
+	min, max := math.NaN(), math.NaN()
+	if SPV.Count > 0 {
+		min, max = SPV.Min, SPV.Max
+	}
+	return append(values, min, max, float64(SPV.Count), SPV.Sum)
+}
+
+// uploadWithRetry 执行上传, 失败时最多重试 retry 次, 返回最后一次的错误
+func uploadWithRetry(retry int, upload func() error) (err error) {
+	for i := 0; i <= retry; i++ {
+		if err = upload(); err == nil {
+			return nil
+		}
+	}
+	return
+}
+
+// uploadTCPBatches 通过 tcp 依次发送每批数据, 每批单独重试, 已发送的批次不会重复发送
+// 发送失败时关闭连接, 重试时重新连接
+func uploadTCPBatches(conf *WriterConfig, msgs [][]byte) error {
+	addr := net.JoinHostPort(conf.UpLoadHost, strconv.Itoa(conf.UpLoadPort))
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for _, msg := range msgs {
+		err := uploadWithRetry(conf.UploadRetry, func() error {
+			if conn == nil {
+				c, err := net.DialTimeout(TCPProtocol, addr, 10*time.Second)
+				if err != nil {
+					return err
+				}
+				conn = c
+			}
+			if _, err := conn.Write(msg); err != nil {
+				conn.Close()
+				conn = nil
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return []string{""}
+// batchSize 返回配置的批量大小, 未配置时返回 def
+func batchSize(conf *WriterConfig, def int) int {
+	if conf.BatchSize > 0 {
+		return conf.BatchSize
+	}
+	return def
 }
 
-// getTempFile 获取一个临时文件
-func getTempFile() (*os.File, error) {
-	return ioutil.TempFile("/tmp", "TempGoMonitorText_")
+// batchBytes 返回配置的每批最大字节数, 未配置时返回 def
+func batchBytes(conf *WriterConfig, def int) int {
+	if conf.BatchBytes > 0 {
+		return conf.BatchBytes
+	}
+	return def
+}
+
+// fileMode 返回配置的文件权限, 未配置时返回 DefaultFileMode
+func fileMode(conf *WriterConfig) os.FileMode {
+	if conf.FileMode != 0 {
+		return conf.FileMode
+	}
+	return DefaultFileMode
 }
 
 // InitWriter 初始化一个 writer